@@ -1,15 +1,27 @@
 package ssh
 
 import (
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"strings"
 )
 
-// Do password authentication
-func (c *Client) auth(user, pass string) bool {
+// The server hung up during authentication.
+var errAuthClosed = fmt.Errorf("connection closed during authentication: %w", io.ErrUnexpectedEOF)
+
+// Authenticate with the methods set in the client option: public keys first,
+// then the host key, then passwords until one is accepted or GetPassword
+// fails.
+func (c *Client) auth() bool {
+	user := c.option.User
+
 	// Service request
 	Log(5, "C->S: Service Request ssh-userauth")
 	c.Packet().Byte(MsgServiceRequest).U32String("ssh-userauth").Commit()
 
-	b := <-c.packets
+	b := c.authReply()
 	//if err != nil { panic(err) }
 
 	var code byte
@@ -17,9 +29,128 @@ func (c *Client) auth(user, pass string) bool {
 	NewDecoder(b).Byte(&code).U32String(&service).End()
 	if (code != MsgServiceAccept) || (service != "ssh-userauth") { panic("Expected MsgServiceAccept") }
 
-	// Password authentication
+	for _, signer := range c.option.Signers {
+		if c.authPublicKey(user, signer) {
+			return true
+		}
+	}
+
+	if (c.option.HostSigner != nil) && c.authHostbased(user, c.option.HostSigner) {
+		return true
+	}
+
+	for c.option.GetPassword != nil {
+		password, err := c.option.GetPassword()
+		if err != nil {
+			return false
+		}
+		if c.authPassword(user, password) {
+			return true
+		}
+	}
+	return false
+}
+
+// Do password authentication
+func (c *Client) authPassword(user, pass string) bool {
 	Log(5, "C->S: Userauth Request password")
 	c.Packet().Byte(MsgUserauthRequest).U32String(user).U32String("ssh-connection").U32String("password").Byte(0).U32String(pass).Commit()
-	b = <-c.packets
+	return c.authSuccess()
+}
+
+// Do public key authentication (RFC4252/7) with a signature made by signer.
+func (c *Client) authPublicKey(user string, signer Signer) bool {
+	algo, pubKey := signer.Algorithm(), signer.PublicKey().Marshal()
+
+	data := NewEncoder().U32Bytes(c.session_id).
+		Byte(MsgUserauthRequest).U32String(user).U32String("ssh-connection").U32String("publickey").
+		Byte(1).U32String(algo).U32Bytes(pubKey).Out()
+	sig, err := signer.Sign(data)
+	if err != nil {
+		Log(5, "publickey sign: %v", err)
+		return false
+	}
+
+	Log(5, "C->S: Userauth Request publickey %v", algo)
+	c.PacketN(len(data)+len(sig)).Bytes(data[4+len(c.session_id):]).U32Bytes(sig).Commit()
+	return c.authSuccess()
+}
+
+// Do host-based authentication (RFC4252/9) with a signature made by the
+// client host key.
+func (c *Client) authHostbased(user string, signer Signer) bool {
+	hostName, localUser := c.option.LocalHostName, c.option.LocalUser
+	if len(hostName) == 0 {
+		name, err := os.Hostname()
+		if err != nil {
+			Log(5, "hostbased: %v", err)
+			return false
+		}
+		hostName = name + "."
+	}
+	if len(localUser) == 0 {
+		u, err := osUser()
+		if err != nil {
+			Log(5, "hostbased: %v", err)
+			return false
+		}
+		localUser = u
+	}
+
+	algo, pubKey := signer.Algorithm(), signer.PublicKey().Marshal()
+	data := NewEncoder().U32Bytes(c.session_id).
+		Byte(MsgUserauthRequest).U32String(user).U32String("ssh-connection").U32String("hostbased").
+		U32String(algo).U32Bytes(pubKey).U32String(hostName).U32String(localUser).Out()
+	sig, err := signer.Sign(data)
+	if err != nil {
+		Log(5, "hostbased sign: %v", err)
+		return false
+	}
+
+	Log(5, "C->S: Userauth Request hostbased %v %v@%v", algo, localUser, hostName)
+	c.PacketN(len(data)+len(sig)).Bytes(data[4+len(c.session_id):]).U32Bytes(sig).Commit()
+	return c.authSuccess()
+}
+
+// Name of the user running the client, without the domain on Windows.
+func osUser() (string, error) {
+	u, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+	name := u.Username
+	if i := strings.LastIndexByte(name, '\\'); i >= 0 {
+		name = name[i+1:]
+	}
+	return name, nil
+}
+
+// Read the answer to an authentication request.
+func (c *Client) authSuccess() bool {
+	b := c.authReply()
 	return (len(b) == 1) && (b[0] == MsgUserauthSuccess)
 }
+
+// Read the next packet during authentication. Banner messages may arrive at
+// any point before the authentication succeeds (RFC4252/5.4); they are passed
+// to the banner callback and skipped.
+func (c *Client) authReply() (b []byte) {
+	for {
+		b = <-c.packets
+		if len(b) == 0 {
+			c.packets <- nil
+			panic(errAuthClosed)
+		}
+		if b[0] != MsgUserauthBanner {
+			return
+		}
+
+		var code byte
+		var message, lang string
+		NewDecoder(b).Byte(&code).U32String(&message).U32String(&lang).End()
+		Log(5, "S->C: Userauth Banner")
+		if c.option.OnBanner != nil {
+			c.option.OnBanner(message, lang)
+		}
+	}
+}
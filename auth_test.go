@@ -0,0 +1,108 @@
+package ssh
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	crand "crypto/rand"
+	"net"
+	"testing"
+
+	xssh "golang.org/x/crypto/ssh"
+)
+
+func TestBanner(t *testing.T) {
+	cfg := &xssh.ServerConfig{
+		BannerCallback: func(xssh.ConnMetadata) string { return "welcome\n" },
+		PasswordCallback: func(_ xssh.ConnMetadata, pw []byte) (*xssh.Permissions, error) {
+			if string(pw) != "p" {
+				return nil, xssh.ErrNoAuth
+			}
+			return nil, nil
+		},
+	}
+
+	// the server sends the banner with the answer to the first attempt, so
+	// the second one comes after it
+	var banners []string
+	attempts := 0
+	o := &ClientOption{User: "u", OnBanner: func(message, lang string) {
+		banners = append(banners, message)
+	}}
+	o.GetPassword = func() (string, error) {
+		attempts++
+		if attempts == 1 {
+			return "wrong", nil
+		}
+		if len(banners) != 1 {
+			t.Error("no banner before authentication completed")
+		}
+		return "p", nil
+	}
+	c, err := NewClient(testServer(t, cfg, nil), o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if (len(banners) != 1) || (banners[0] != "welcome\n") {
+		t.Fatal(banners)
+	}
+}
+
+// Transport without keys over conn, so a test can play the server's side of
+// authentication.
+func rawTransport(conn net.Conn) *transport {
+	return &transport{conn: conn, in: bufio.NewReader(conn), rc: NullCrypto{}, wc: NullCrypto{}, rh: NullHash{}, wh: NullHash{},
+		packets: make(chan []byte, 16), newkey: make(chan int, 1), session_id: []byte("session id")}
+}
+
+func TestHostbasedAuth(t *testing.T) {
+	_, k, _ := ed25519.GenerateKey(crand.Reader)
+	hostKey, _ := NewSigner(k)
+	local, err := osUser()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	for _, c := range []struct{ hostName, localUser, wantHost, wantUser string }{
+		{"client.example.", "bob", "client.example.", "bob"},
+		{"client.example.", "", "client.example.", local},
+	} {
+		a, b := net.Pipe()
+		client := &Client{transport: rawTransport(a), option: &ClientOption{User: "alice", LocalHostName: c.hostName, LocalUser: c.localUser}}
+		server := rawTransport(b)
+		result := make(chan bool)
+		go func() { result <- client.authHostbased("alice", hostKey) }()
+
+		// the server checks the signature over the session id and request
+		req, err := server.readPacket()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var code byte
+		var user, service, method, algo, hostName, localUser string
+		var pub, sig []byte
+		p := NewDecoder(req).Byte(&code).U32String(&user).U32String(&service).U32String(&method).
+			U32String(&algo).U32Bytes(&pub).U32String(&hostName).U32String(&localUser)
+		signed := append([]byte(nil), req[:p.offset]...)
+		p.U32Bytes(&sig).End()
+		if (code != MsgUserauthRequest) || (user != "alice") || (method != "hostbased") || (algo != hostKey.Algorithm()) ||
+			(hostName != c.wantHost) || (localUser != c.wantUser) {
+			t.Fatal(user, method, algo, hostName, localUser)
+		}
+		key, err := ParsePublicKey(pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := NewEncoder().U32Bytes(server.session_id).Bytes(signed).Out()
+		if err := key.Verify(data, sig); err != nil {
+			t.Fatal(err)
+		}
+
+		client.packets <- []byte{MsgUserauthSuccess}
+		if !<-result {
+			t.Fatal("hostbased authentication failed")
+		}
+		a.Close()
+		b.Close()
+	}
+}
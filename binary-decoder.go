@@ -2,6 +2,7 @@ package ssh
 
 import (
     "errors"
+    "math/big"
 )
 
 // Decoder type.
@@ -81,6 +82,21 @@ func (p *Decoder) U8String(d *string) *Decoder {
     return p.Byte(&v).NString(int(v), d)
 }
 
+// Parse a multiple precision integer (RFC4251/5 mpint) to a *big.Int pointer.
+func (p *Decoder) MPInt(d **big.Int) *Decoder {
+    var b []byte
+    p.U32Bytes(&b)
+    *d = pS(b)
+    return p
+}
+
+// Parse the rest of the buffer to a []byte pointer.
+func (p *Decoder) Rest(d *[]byte) *Decoder {
+    *d = p.buf[p.offset:]
+    p.offset = len(p.buf)
+    return p
+}
+
 // Assert that we are at the end of input.
 func (p *Decoder) End() {
     if !p.IsEnd() {
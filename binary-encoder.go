@@ -3,6 +3,7 @@ package ssh
 import (
     "encoding/binary"
     "errors"
+    "math/big"
 )
 
 var bigendian = binary.BigEndian
@@ -73,6 +74,11 @@ func (p *Encoder) U32Bytes(d []byte) *Encoder {
 	return p.U32(uint32(len(d))).Bytes(d)
 }
 
+// Output a non-negative multiple precision integer (RFC4251/5 mpint).
+func (p *Encoder) MPInt(d *big.Int) *Encoder {
+	return p.U32Bytes(bS(d))
+}
+
 // Output a string with a 2 byte bigendian length prefix and no trailing null.
 func (p *Encoder) U16String(d string) *Encoder {
 	if len(d) > 0xffff {
@@ -0,0 +1,48 @@
+package ssh
+
+// Answer MsgChannelOpen from the server. The handler registered for the
+// channel type in c.channelTypes returns the channel to confirm, or an error,
+// an *OpenChannelError to choose the reason.
+func (c *Client) acceptChannel(chanType string, remoteId, remoteWindow, remoteMaxPacketSize uint32, data []byte) {
+	var ch *Channel
+	err := error(&OpenChannelError{OpenUnknownChannelType, "unknown channel type " + chanType})
+	if accept := c.channelTypes[chanType]; accept != nil {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					err = &OpenChannelError{OpenConnectFailed, "malformed channel open"}
+				}
+			}()
+			ch, err = accept(data)
+		}()
+	}
+
+	if ch == nil {
+		reason, message := uint32(OpenConnectFailed), "refused"
+		if e, ok := err.(*OpenChannelError); ok {
+			reason, message = e.Reason, e.Message
+		} else if err != nil {
+			message = err.Error()
+		}
+		Log(5, "refusing %v channel: %v", chanType, message)
+		c.PacketN(len(message) + 16).Byte(MsgChannelOpenFailure).U32(remoteId).U32(reason).U32String(message).U32String("").Commit()
+		return
+	}
+
+	ch.client = c
+	ch.initWindow()
+	ch.localId = c.channelAdd(ch)
+	ch.remoteId = remoteId
+	ch.remoteWindow = remoteWindow
+	ch.remoteMaxPacketSize = remoteMaxPacketSize
+	ch.opened = true
+	c.Packet().Byte(MsgChannelOpenConfirmation).U32(remoteId).U32(ch.localId).U32(ch.localWindow).U32(MaxPacketSize).Commit()
+}
+
+// Accept channels of chanType with accept, from the client loop.
+func (c *Client) handleChannelType(chanType string, accept func(data []byte) (*Channel, error)) {
+	if c.channelTypes == nil {
+		c.channelTypes = map[string]func(data []byte) (*Channel, error){}
+	}
+	c.channelTypes[chanType] = accept
+}
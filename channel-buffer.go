@@ -0,0 +1,139 @@
+package ssh
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Queue of data received on a channel, filled by the client loop and drained
+// by a reader. Its size is bounded by the channel window.
+type chanBuffer struct {
+	mutex sync.Mutex
+	cond  *sync.Cond
+
+	packets [][]byte
+	eof     bool
+
+	// Closed by the reader; reads fail with net.ErrClosed.
+	closed bool
+
+	deadline condDeadline
+
+	// Called with the number of bytes each read took.
+	consumed func(n int)
+}
+
+func newChanBuffer(consumed func(n int)) *chanBuffer {
+	b := &chanBuffer{consumed: consumed}
+	b.cond = sync.NewCond(&b.mutex)
+	b.deadline.cond = b.cond
+	return b
+}
+
+func (b *chanBuffer) write(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+
+	b.mutex.Lock()
+	b.packets = append(b.packets, data)
+	b.cond.Signal()
+	b.mutex.Unlock()
+}
+
+// Mark the end of data; pending data can still be read.
+func (b *chanBuffer) close() {
+	b.mutex.Lock()
+	b.eof = true
+	b.cond.Broadcast()
+	b.mutex.Unlock()
+}
+
+// Fail pending and later reads with net.ErrClosed.
+func (b *chanBuffer) shutdown() {
+	b.mutex.Lock()
+	b.closed = true
+	b.packets = nil
+	b.cond.Broadcast()
+	b.mutex.Unlock()
+}
+
+func (b *chanBuffer) setDeadline(t time.Time) {
+	b.mutex.Lock()
+	b.deadline.set(t)
+	b.mutex.Unlock()
+}
+
+func (b *chanBuffer) Read(buf []byte) (n int, err error) {
+	b.mutex.Lock()
+	for {
+		if b.closed {
+			b.mutex.Unlock()
+			return 0, net.ErrClosed
+		}
+		if b.deadline.exceeded() {
+			b.mutex.Unlock()
+			return 0, os.ErrDeadlineExceeded
+		}
+		if (len(buf) == 0) || (len(b.packets) > 0) || b.eof {
+			break
+		}
+		b.cond.Wait()
+	}
+	if len(buf) == 0 {
+		b.mutex.Unlock()
+		return
+	}
+
+	for (len(b.packets) > 0) && (n < len(buf)) {
+		m := copy(buf[n:], b.packets[0])
+		n += m
+		if m < len(b.packets[0]) {
+			b.packets[0] = b.packets[0][m:]
+		} else {
+			b.packets[0] = nil
+			b.packets = b.packets[1:]
+		}
+	}
+	if n == 0 {
+		err = io.EOF
+	}
+	b.mutex.Unlock()
+
+	if (n > 0) && (b.consumed != nil) {
+		b.consumed(n)
+	}
+	return
+}
+
+// Deadline of operations waiting on cond, which it wakes when the deadline
+// passes. Methods are called with cond.L held.
+type condDeadline struct {
+	cond  *sync.Cond
+	t     time.Time
+	timer *time.Timer
+}
+
+func (d *condDeadline) set(t time.Time) {
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	d.t = t
+	if wait := time.Until(t); !t.IsZero() && (wait > 0) {
+		cond := d.cond
+		d.timer = time.AfterFunc(wait, func() {
+			cond.L.Lock()
+			cond.Broadcast()
+			cond.L.Unlock()
+		})
+	}
+	d.cond.Broadcast()
+}
+
+func (d *condDeadline) exceeded() bool {
+	return !d.t.IsZero() && !time.Now().Before(d.t)
+}
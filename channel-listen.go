@@ -0,0 +1,218 @@
+package ssh
+
+import (
+	"errors"
+	"net"
+	"strconv"
+)
+
+// Listener for connections forwarded by the server (RFC4254/7.1).
+type forwardListener struct {
+	client *Client
+
+	// Key in Client.forwards, the bound address.
+	key  string
+	addr net.Addr
+	port int
+
+	conns  chan net.Conn
+	closed chan int
+
+	// Set in the client loop once closed.
+	closing bool
+
+	// Global request that stops the forwarding.
+	cancel     string
+	cancelData []byte
+}
+
+// Ask the server to listen on addr, "host:port", and forward connections to
+// the returned listener (tcpip-forward). Port 0 lets the server choose one,
+// see Addr. An empty host listens on all addresses, "localhost" on loopback
+// only. Accepted connections report the server side address and the
+// originator as LocalAddr and RemoteAddr.
+func (c *Client) Listen(network, addr string) (net.Listener, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, errors.New("unsupported network " + network)
+	}
+
+	host, p, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(p)
+	if (err != nil) || (port < 0) || (port > 65535) {
+		return nil, errors.New("invalid port in " + addr)
+	}
+
+	l := &forwardListener{client: c, conns: make(chan net.Conn, 16), closed: make(chan int)}
+	err = c.listen("tcpip-forward", NewEncoder().U32String(host).U32(uint32(port)).Out(), func(data []byte) {
+		if port == 0 {
+			var bound uint32
+			NewDecoder(data).U32(&bound).End()
+			port = int(bound)
+		}
+		l.key = tcpForwardKey(host, port)
+		l.addr = tcpAddr(host, port)
+		l.port = port
+		l.cancel = "cancel-tcpip-forward"
+		l.cancelData = NewEncoder().U32String(host).U32(uint32(port)).Out()
+		c.addForward(l, "forwarded-tcpip", c.acceptForwardedTCP)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Send the global request that starts a forwarding and call bound with the
+// reply data from the client loop once the server accepted it, so that no
+// forwarded channel arrives before the listener is registered.
+func (c *Client) listen(name string, payload []byte, bound func(data []byte)) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = ErrClientClosed
+		}
+	}()
+
+	result := make(chan error, 1)
+	c.do(func() {
+		err := c.sendRequest(name, payload, func(ok bool, data []byte, err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					result <- errors.New(name + ": malformed reply")
+				}
+			}()
+
+			if (err == nil) && !ok {
+				err = errors.New(name + " request refused")
+			}
+			if err == nil {
+				bound(data)
+			}
+			result <- err
+		})
+		if err != nil {
+			result <- err
+		}
+	})
+	return <-result
+}
+
+func tcpForwardKey(host string, port int) string {
+	return "tcp " + net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// Register l in the client loop, with accept for its channel type.
+func (c *Client) addForward(l *forwardListener, chanType string, accept func(data []byte) (*Channel, error)) {
+	if c.forwards == nil {
+		c.forwards = map[string]*forwardListener{}
+	}
+	c.forwards[l.key] = l
+	c.handleChannelType(chanType, accept)
+}
+
+func (c *Client) acceptForwardedTCP(data []byte) (*Channel, error) {
+	var host, originHost string
+	var port, originPort uint32
+	NewDecoder(data).U32String(&host).U32(&port).U32String(&originHost).U32(&originPort).End()
+
+	l := c.forwards[tcpForwardKey(host, int(port))]
+	if l == nil {
+		// the server may report the address in another form than requested
+		for _, f := range c.forwards {
+			if (f.cancel == "cancel-tcpip-forward") && (f.port == int(port)) {
+				l = f
+				break
+			}
+		}
+	}
+	if l == nil {
+		return nil, &OpenChannelError{OpenAdministrativelyProhibited, "no forwarding for " + net.JoinHostPort(host, strconv.Itoa(int(port)))}
+	}
+
+	conn := newTunnelConn(c)
+	conn.laddr = tcpAddr(host, int(port))
+	conn.raddr = tcpAddr(originHost, int(originPort))
+	return l.deliver(conn)
+}
+
+// Queue conn for Accept without blocking the client loop.
+func (l *forwardListener) deliver(conn *TunnelConn) (*Channel, error) {
+	select {
+	case l.conns <- conn:
+		return conn.Channel, nil
+	default:
+		return nil, &OpenChannelError{OpenResourceShortage, "too many pending connections"}
+	}
+}
+
+func (l *forwardListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Stop the forwarding; pending connections are closed. Closing again, or
+// after the connection ended, returns net.ErrClosed.
+func (l *forwardListener) Close() (err error) {
+	defer l.closePending()
+	defer func() {
+		if r := recover(); r != nil {
+			// the client loop ended and stopped all forwardings
+			err = net.ErrClosed
+		}
+	}()
+
+	c := l.client
+	done := make(chan int)
+	c.do(func() {
+		defer close(done)
+		if l.closing {
+			err = net.ErrClosed
+			return
+		}
+		l.shutdown()
+		err = c.sendRequest(l.cancel, l.cancelData, func(ok bool, data []byte, err error) {
+			if !ok {
+				Log(5, "%v refused %v", l.cancel, err)
+			}
+		})
+	})
+	<-done
+	return
+}
+
+func (l *forwardListener) closePending() {
+	for {
+		select {
+		case conn := <-l.conns:
+			conn.Close()
+		default:
+			return
+		}
+	}
+}
+
+// Unregister l, in the client loop.
+func (l *forwardListener) shutdown() {
+	l.closing = true
+	delete(l.client.forwards, l.key)
+	close(l.closed)
+}
+
+func (l *forwardListener) Addr() net.Addr {
+	return l.addr
+}
+
+// End all forwardings once the connection is gone.
+func (c *Client) dropForwards() {
+	for _, l := range c.forwards {
+		l.shutdown()
+	}
+}
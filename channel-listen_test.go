@@ -0,0 +1,196 @@
+package ssh
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	xssh "golang.org/x/crypto/ssh"
+)
+
+// Serve tcpip-forward requests: listen on the requested address and open a
+// forwarded-tcpip channel for each connection. The names of received cancel
+// requests are sent to cancels.
+func forwardServer(cancels chan<- string) serverHandler {
+	return func(sc *xssh.ServerConn, chans <-chan xssh.NewChannel, reqs <-chan *xssh.Request) {
+		var mutex sync.Mutex
+		listeners := map[string]net.Listener{}
+		defer func() {
+			for _, ln := range listeners {
+				ln.Close()
+			}
+		}()
+		go func() {
+			for nc := range chans {
+				nc.Reject(xssh.Prohibited, "no channels")
+			}
+		}()
+
+		for r := range reqs {
+			var addr struct {
+				Host string
+				Port uint32
+			}
+			xssh.Unmarshal(r.Payload, &addr)
+			address := net.JoinHostPort(addr.Host, strconv.Itoa(int(addr.Port)))
+
+			switch r.Type {
+			case "tcpip-forward":
+				ln, err := net.Listen("tcp", address)
+				if err != nil {
+					r.Reply(false, nil)
+					continue
+				}
+				port := uint32(ln.Addr().(*net.TCPAddr).Port)
+				mutex.Lock()
+				listeners[net.JoinHostPort(addr.Host, strconv.Itoa(int(port)))] = ln
+				mutex.Unlock()
+				var reply []byte
+				if addr.Port == 0 {
+					reply = xssh.Marshal(struct{ Port uint32 }{port})
+				}
+				r.Reply(true, reply)
+				go acceptForwarded(sc, ln, func(origin *net.TCPAddr) []byte {
+					return xssh.Marshal(struct {
+						Host       string
+						Port       uint32
+						OriginHost string
+						OriginPort uint32
+					}{addr.Host, port, origin.IP.String(), uint32(origin.Port)})
+				})
+
+			case "cancel-tcpip-forward":
+				mutex.Lock()
+				ln := listeners[address]
+				delete(listeners, address)
+				mutex.Unlock()
+				if ln != nil {
+					ln.Close()
+				}
+				r.Reply(ln != nil, nil)
+				cancels <- r.Type
+
+			default:
+				r.Reply(false, nil)
+			}
+		}
+	}
+}
+
+// Open a forwarded-tcpip channel for every connection to ln and copy between
+// them.
+func acceptForwarded(sc *xssh.ServerConn, ln net.Listener, data func(origin *net.TCPAddr) []byte) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		ch, reqs, err := sc.OpenChannel("forwarded-tcpip", data(conn.RemoteAddr().(*net.TCPAddr)))
+		if err != nil {
+			conn.Close()
+			continue
+		}
+		go xssh.DiscardRequests(reqs)
+		go pipeChannel(ch, conn)
+	}
+}
+
+func pipeChannel(ch xssh.Channel, conn net.Conn) {
+	go func() {
+		io.Copy(ch, conn)
+		ch.CloseWrite()
+	}()
+	io.Copy(conn, ch)
+	conn.Close()
+	ch.Close()
+}
+
+func TestListen(t *testing.T) {
+	cancels := make(chan string, 4)
+	c := dialTest(t, forwardServer(cancels))
+	defer c.Close()
+
+	l, err := c.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().(*net.TCPAddr)
+	if (addr.Port == 0) || !addr.IP.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Fatal(addr)
+	}
+
+	peer, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (conn.LocalAddr().String() != addr.String()) || (conn.RemoteAddr().String() != peer.LocalAddr().String()) {
+		t.Fatal(conn.LocalAddr(), conn.RemoteAddr())
+	}
+	peer.Write([]byte("ping"))
+	b := make([]byte, 4)
+	if _, err := io.ReadFull(conn, b); (err != nil) || (string(b) != "ping") {
+		t.Fatal(string(b), err)
+	}
+	conn.Write([]byte("pong"))
+	if _, err := io.ReadFull(peer, b); (err != nil) || (string(b) != "pong") {
+		t.Fatal(string(b), err)
+	}
+	conn.Close()
+
+	// Close cancels the forwarding and unblocks Accept
+	accepted := make(chan error)
+	go func() {
+		_, err := l.Accept()
+		accepted <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-accepted; !errors.Is(err, net.ErrClosed) {
+		t.Fatal(err)
+	}
+	select {
+	case name := <-cancels:
+		if name != "cancel-tcpip-forward" {
+			t.Fatal(name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no cancel request")
+	}
+	if err := l.Close(); !errors.Is(err, net.ErrClosed) {
+		t.Fatal(err)
+	}
+	if _, err := net.Dial("tcp", addr.String()); err == nil {
+		t.Fatal("server still listening")
+	}
+}
+
+func TestListenClientClosed(t *testing.T) {
+	c := dialTest(t, forwardServer(make(chan string, 4)))
+	l, err := c.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if _, err := l.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := l.Close(); !errors.Is(err, net.ErrClosed) {
+			t.Fatal(err)
+		}
+	}
+	if _, err := c.Listen("tcp", "127.0.0.1:0"); !errors.Is(err, ErrClientClosed) {
+		t.Fatal("listening on a closed client")
+	}
+}
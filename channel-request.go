@@ -0,0 +1,122 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+)
+
+// Send a channel request (RFC4254/5.4). With wantReply wait for the server's
+// answer and report whether it succeeded; replies are matched to requests in
+// order. Once the connection is closed it fails with ErrClientClosed.
+func (ch *Channel) SendRequest(name string, wantReply bool, payload []byte) (bool, error) {
+	return ch.SendRequestContext(context.Background(), name, wantReply, payload)
+}
+
+// Like SendRequest, giving up waiting when ctx is done. A reply arriving
+// later is dropped.
+func (ch *Channel) SendRequestContext(ctx context.Context, name string, wantReply bool, payload []byte) (ok bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = ErrClientClosed
+		}
+	}()
+
+	type answer struct {
+		ok  bool
+		err error
+	}
+	result := make(chan answer, 2)
+	ch.client.do(func() {
+		var reply func(bool, error)
+		if wantReply {
+			reply = func(ok bool, err error) {
+				result <- answer{ok, err}
+			}
+		}
+		err := ch.sendRequest(name, payload, reply)
+		result <- answer{err == nil, err}
+	})
+
+	for sent := false; ; sent = true {
+		select {
+		case a := <-result:
+			if sent || (a.err != nil) || !wantReply {
+				return a.ok, a.err
+			}
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+}
+
+// Send a channel request from the client loop. A non-nil reply asks for an
+// answer and is called with it, or with an error when the channel closes
+// first.
+func (ch *Channel) sendRequest(name string, payload []byte, reply func(ok bool, err error)) (err error) {
+	if !ch.opened {
+		return errors.New("request on closed channel")
+	}
+
+	p := ch.client.PacketN(len(name)+len(payload)+16).Byte(MsgChannelRequest).U32(ch.remoteId).U32String(name)
+	if reply != nil {
+		p.Byte(1)
+	} else {
+		p.Byte(0)
+	}
+	if _, err = p.Bytes(payload).Commit(); err != nil {
+		return
+	}
+
+	if reply != nil {
+		ch.replies = append(ch.replies, reply)
+	}
+	return
+}
+
+// Deliver MsgChannelSuccess or MsgChannelFailure to the oldest request. A
+// reply nothing waits for is dropped.
+func (ch *Channel) handleReply(ok bool) {
+	if len(ch.replies) == 0 {
+		Log(5, "unexpected channel request reply on %v", ch.localId)
+		return
+	}
+	reply := ch.replies[0]
+	ch.replies = ch.replies[1:]
+	reply(ok, nil)
+}
+
+// Fail the requests still waiting for a reply once the channel is closed.
+func (ch *Channel) dropReplies() {
+	replies := ch.replies
+	ch.replies = nil
+	for _, reply := range replies {
+		reply(false, errors.New("channel closed before request reply"))
+	}
+}
+
+// Answer a request from the server. OnChannelRequest sees it first; requests
+// it does not accept fall back to the defaults below.
+func (ch *Channel) handleRequest(name string, wantReply bool, data []byte) bool {
+	if (ch.OnChannelRequest != nil) && ch.OnChannelRequest(ch, name, wantReply, data) {
+		return true
+	}
+
+	switch name {
+	case "exit-status":
+		var status uint32
+		NewDecoder(data).U32(&status).End()
+		Log(5, "channel %v exit status %v", ch.localId, status)
+		return true
+
+	case "xon-xoff":
+		var clientCanDo byte
+		NewDecoder(data).Byte(&clientCanDo).End()
+		ch.xonXoff = clientCanDo != 0
+		return true
+
+	case "keepalive@openssh.com":
+		// Any answer proves that we are alive; OpenSSH answers with failure.
+		return false
+	}
+	return false
+}
@@ -0,0 +1,40 @@
+package ssh
+
+import (
+	"testing"
+
+	xssh "golang.org/x/crypto/ssh"
+)
+
+func TestChannelStrayReply(t *testing.T) {
+	c := dialTest(t, func(sc *xssh.ServerConn, chans <-chan xssh.NewChannel, reqs <-chan *xssh.Request) {
+		go xssh.DiscardRequests(reqs)
+		for nc := range chans {
+			ch, creqs, _ := nc.Accept()
+			go func() {
+				for r := range creqs {
+					r.Reply(r.Type == "ok", nil)
+				}
+				ch.Close()
+			}()
+		}
+	})
+	defer c.Close()
+
+	s, err := c.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// as if the server answered a request twice
+	c.do(func() {
+		s.ch.handleReply(true)
+	})
+	if ok, err := s.ch.SendRequest("ok", true, nil); !ok || (err != nil) {
+		t.Fatal(ok, err)
+	}
+	if ok, err := s.ch.SendRequest("other", true, nil); ok || (err != nil) {
+		t.Fatal(ok, err)
+	}
+}
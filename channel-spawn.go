@@ -20,16 +20,26 @@ func Spawn(command string, in io.ReadCloser, out, err io.WriteCloser, noTTY bool
         ch.OnChannelOpenConfirmation = func(ch *Channel) {
             c := ch.Client()
             if !noTTY {
+                // size and modes of the local terminal, if there is one
+                fd := int(os.Stdin.Fd())
+                rows, cols, _ := TerminalSize(fd)
+                modes, _ := TerminalModesFromFd(fd)
                 c.Packet().
                     Byte(MsgChannelRequest).U32(ch.remoteId).U32String("pty-req").
-                    Byte(0).U32String(os.Getenv("TERM")).
-                    U32(0).U32(0).U32(0).U32(0).U32String("").
+                    Byte(0).Bytes(ptyRequestData(os.Getenv("TERM"), rows, cols, modes)).
                     Commit()
             }
+            // close the channel if the server refuses to start the command
+            started := func(ok bool, err error) {
+                if !ok {
+                    Log(5, "Spawn %q refused %v", command, err)
+                    ch.sendClose()
+                }
+            }
             if len(command) == 0 {
-                c.Packet().Byte(MsgChannelRequest).U32(ch.remoteId).U32String("shell").Byte(1).Commit()
+                ch.sendRequest("shell", nil, started)
             } else {
-                c.Packet().Byte(MsgChannelRequest).U32(ch.remoteId).U32String("exec").Byte(1).U32String(command).Commit()
+                ch.sendRequest("exec", NewEncoder().U32String(command).Out(), started)
             }
         }
 
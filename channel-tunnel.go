@@ -1,134 +1,196 @@
 package ssh
 
 import (
+    "context"
     "errors"
-    "io"
+    "net"
+    "strconv"
+    "sync"
+    "time"
 )
 
+// A channel carrying a byte stream, e.g. a forwarded TCP connection, as a
+// net.Conn.
 type TunnelConn struct {
     *Channel
-    packet []byte
-    packets chan []byte
+    buffer *chanBuffer
+
+    // Both ends as seen by the server.
+    laddr, raddr net.Addr
+
+    mutex sync.Mutex
+    closed bool
+
+    // Closed with the channel, by either side or as the connection ended.
+    channelClosed chan int
+}
+
+// Address of a forwarded connection end; host names are kept as given.
+type tunnelAddr struct {
+    network, address string
+}
+
+func (a *tunnelAddr) Network() string {
+    return a.network
 }
 
-func (s *TunnelConn) send(data []byte) {
-    s.packets<- data
+func (a *tunnelAddr) String() string {
+    return a.address
 }
 
-func (s *TunnelConn) recv() {
-    select {
-    case s.packet = <-s.packets:
-    default:
-        s.moreData()
-        s.packet = <-s.packets
+func tcpAddr(host string, port int) net.Addr {
+    if ip := net.ParseIP(host); ip != nil {
+        return &net.TCPAddr{IP: ip, Port: port}
     }
+    return &tunnelAddr{"tcp", net.JoinHostPort(host, strconv.Itoa(port))}
 }
 
-func (s *TunnelConn) init() {
-    <-s.packets
+func (s *TunnelConn) LocalAddr() net.Addr {
+    return s.laddr
 }
 
-func (s *TunnelConn) Read(buf []byte) (n int, err error) {
-    if len(buf) > 0 {
-        if len(s.packet) == 0 {
-            s.recv()
-        }
+func (s *TunnelConn) RemoteAddr() net.Addr {
+    return s.raddr
+}
 
-        if len(s.packet) > 0 {
-            n = copy(buf, s.packet)
-            s.packet = s.packet[n:]
-        } else {
-            s.send(nil)
-            err = io.EOF
-        }
-    }
-    return
+func (s *TunnelConn) SetDeadline(t time.Time) error {
+    s.SetReadDeadline(t)
+    return s.SetWriteDeadline(t)
 }
 
-func (s *TunnelConn) Read2(buf []byte) (data []byte, err error) {
-    n := len(buf)
+// Reads waiting past t fail with os.ErrDeadlineExceeded; the zero time
+// disables the deadline.
+func (s *TunnelConn) SetReadDeadline(t time.Time) error {
+    s.buffer.setDeadline(t)
+    return nil
+}
 
-    if n == 0 {
-        return
-    }
+func (s *TunnelConn) SetWriteDeadline(t time.Time) error {
+    s.setWriteDeadline(t)
+    return nil
+}
 
-    if len(s.packet) == 0 {
-        s.recv()
-    }
+// Read data until the server sends EOF or closes the channel, then io.EOF.
+func (s *TunnelConn) Read(buf []byte) (n int, err error) {
+    return s.buffer.Read(buf)
+}
 
-    if m := len(s.packet); m > 0 {
-        if m > n {
-            data, s.packet = s.packet[:n], s.packet[n:]
-        } else {
-            data, s.packet = s.packet, nil
-        }
-    } else {
-        err = io.EOF
+// Shut down the writing side like (*net.TCPConn).CloseWrite: data written so
+// far is flushed, then the server gets EOF. Reads continue until the server
+// closes its side; writes fail with io.ErrClosedPipe.
+func (s *TunnelConn) CloseWrite() error {
+    s.mutex.Lock()
+    closed := s.closed
+    s.mutex.Unlock()
+
+    if closed {
+        return net.ErrClosed
     }
+    return s.Channel.CloseWrite()
+}
 
-    return
+// Close the channel; pending and later reads and writes fail with
+// net.ErrClosed.
+func (s *TunnelConn) Close() error {
+    s.mutex.Lock()
+    closed := s.closed
+    s.closed = true
+    s.mutex.Unlock()
+
+    if closed {
+        return net.ErrClosed
+    }
+    s.buffer.shutdown()
+    return s.Channel.Close()
 }
 
-func (c *Client) DialTCP(host string, port int) (conn *TunnelConn, err error) {
-    conn = &TunnelConn{packets: make(chan []byte, 128)}
+// Make a TunnelConn for a channel opened by either side.
+func newTunnelConn(c *Client) *TunnelConn {
+    conn := &TunnelConn{channelClosed: make(chan int)}
 
-	ch := &Channel{localWindow: 128*1024, client: c, opened: false, ChannelSink: &ChannelSink {
+    ch := &Channel{client: c, opened: false, bufferedReads: true}
+    conn.buffer = newChanBuffer(ch.consume)
+    ch.ChannelSink = &ChannelSink {
         OnChannelEOF: func(ch *Channel) {
             Log(20, "OnChannelEOF %v", ch.RemoteId())
-            //conn.send(nil)
+            conn.buffer.close()
         },
 
         OnChannelClose: func(ch *Channel) {
             Log(20, "OnChannelClose %v", ch.RemoteId())
-            conn.send(nil)
+            conn.buffer.close()
+            close(conn.channelClosed)
         },
 
         OnChannelData: func(ch *Channel, data []byte) {
-            conn.send(data)
+            conn.buffer.write(data)
         },
 
         OnChannelExtendedData: func(ch *Channel, data []byte, dataType uint32) {
             Log(20, "OnChannelExtendedData %v %v", ch.RemoteId(), dataType)
+            ch.discard(len(data))
         },
+    }
+    conn.Channel = ch
+    return conn
+}
 
-        OnChannelOpenConfirmation: func(ch *Channel) {
-            Log(20, "OnChannelOpenConfirmation %v", ch.RemoteId())
-            conn.send(nil)
-        },
+func (c *Client) DialTCP(host string, port int) (conn *TunnelConn, err error) {
+    return c.DialTCPFrom(host, port, nil)
+}
 
-        OnChannelOpenFailure: func(ch *Channel, reason uint32, description, lang string) {
-            Log(20, "OnChannelOpenFailure reason: [%v] description: [%v]", reason, description)
-            err = errors.New("open channel failure")
-            conn.send(nil)
-        },
-    }}
-    conn.Channel = ch
+// Like DialTCP, telling the server that the connection originates from
+// originator, e.g. the peer of a locally accepted connection. A nil
+// originator is sent as 0.0.0.0:0.
+func (c *Client) DialTCPFrom(host string, port int, originator net.Addr) (conn *TunnelConn, err error) {
+    originHost, originPort := splitOriginator(originator)
+    return c.dialTCP(context.Background(), host, port, originHost, originPort)
+}
 
-	c.do(func() {
-        /* ChanType:      "direct-tcpip",
-           PeersId:       localId,
-           PeersWindow:   ch.localWindow,
-           MaxPacketSize: MaxPacketSize,
-           raddr:         host,
-           rport:         uint32(port),
-           laddr:         "0.0.0.0",
-           lport:         uint32(0),
-        */
-
-        localId := c.channelAdd(ch)
-        _, err = ch.Client().Packet().Byte(MsgChannelOpen).
-            U32String("direct-tcpip").U32(localId).U32(ch.localWindow).U32(MaxPacketSize).
-            U32String(host).U32(uint32(port)).
-            U32String("0.0.0.0").U32(0).
-            Commit()
+func splitOriginator(originator net.Addr) (host string, port int) {
+    host = "0.0.0.0"
+    if originator != nil {
+        if h, p, e := net.SplitHostPort(originator.String()); e == nil {
+            host = h
+            port, _ = strconv.Atoi(p)
+        }
+    }
+    return
+}
+
+func (c *Client) dialTCP(ctx context.Context, host string, port int, originHost string, originPort int) (*TunnelConn, error) {
+    data := NewEncoder().U32String(host).U32(uint32(port)).U32String(originHost).U32(uint32(originPort)).Out()
+    return c.dialTunnel(ctx, "direct-tcpip", data, tcpAddr(originHost, originPort), tcpAddr(host, port))
+}
+
+// Connect to addr from the server like (*net.Dialer).DialContext, so a Client
+// can dial for e.g. http.Transport. The network is "tcp", "tcp4", "tcp6" or
+// "unix". When ctx is done before the server answers, the open is abandoned.
+func (c *Client) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+    switch network {
+    case "tcp", "tcp4", "tcp6":
+        host, p, err := net.SplitHostPort(addr)
         if err != nil {
-            c.channelDel(localId)
-            conn.send(nil)
+            return nil, err
         }
-	})
+        port, err := strconv.Atoi(p)
+        if err != nil {
+            return nil, errors.New("invalid port in " + addr)
+        }
+        return c.dialTCP(ctx, host, port, "0.0.0.0", 0)
+    case "unix":
+        return c.dialUnix(ctx, addr)
+    }
+    return nil, errors.New("unsupported network " + network)
+}
 
-    if conn.init(); err != nil {
-        conn = nil
+// Open a channel of chanType with the type specific data as a TunnelConn.
+func (c *Client) dialTunnel(ctx context.Context, chanType string, data []byte, laddr, raddr net.Addr) (*TunnelConn, error) {
+    conn := newTunnelConn(c)
+    conn.laddr = laddr
+    conn.raddr = raddr
+    if err := c.openChannel(ctx, chanType, data, conn.Channel); err != nil {
+        return nil, err
     }
-    return
+    return conn, nil
 }
@@ -0,0 +1,140 @@
+package ssh
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	xssh "golang.org/x/crypto/ssh"
+	"golang.org/x/net/nettest"
+)
+
+// Connect direct-tcpip channels to their TCP targets, closing each channel
+// once its target closed.
+func directTCPServer(sc *xssh.ServerConn, chans <-chan xssh.NewChannel, reqs <-chan *xssh.Request) {
+	go xssh.DiscardRequests(reqs)
+	for nc := range chans {
+		var d struct {
+			Host       string
+			Port       uint32
+			OriginHost string
+			OriginPort uint32
+		}
+		if err := xssh.Unmarshal(nc.ExtraData(), &d); err != nil {
+			nc.Reject(xssh.Prohibited, err.Error())
+			continue
+		}
+		conn, err := net.Dial("tcp", net.JoinHostPort(d.Host, strconv.Itoa(int(d.Port))))
+		if err != nil {
+			nc.Reject(xssh.ConnectionFailed, err.Error())
+			continue
+		}
+		ch, creqs, _ := nc.Accept()
+		go xssh.DiscardRequests(creqs)
+		go func() {
+			io.Copy(ch, conn)
+			ch.CloseWrite()
+			conn.Close()
+			ch.Close()
+		}()
+		go func() {
+			io.Copy(conn, ch)
+			conn.(*net.TCPConn).CloseWrite()
+		}()
+	}
+}
+
+// Tunnel to a local listener, returning both ends.
+func dialTunnelPair(t *testing.T, c *Client) (*TunnelConn, net.Conn) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	conn, err := c.DialTCP("127.0.0.1", ln.Addr().(*net.TCPAddr).Port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, peer
+}
+
+func TestTunnelConn(t *testing.T) {
+	c := dialTest(t, directTCPServer)
+	defer c.Close()
+
+	nettest.TestConn(t, func() (c1, c2 net.Conn, stop func(), err error) {
+		conn, peer := dialTunnelPair(t, c)
+		return conn, peer, func() {
+			conn.Close()
+			peer.Close()
+		}, nil
+	})
+}
+
+func TestTunnelConnDeadline(t *testing.T) {
+	c := dialTest(t, directTCPServer)
+	defer c.Close()
+	conn, peer := dialTunnelPair(t, c)
+	defer peer.Close()
+
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := conn.Read(make([]byte, 10)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Time{})
+	peer.Write([]byte("hi"))
+	b := make([]byte, 10)
+	if n, err := conn.Read(b); (string(b[:n]) != "hi") || (err != nil) {
+		t.Fatal(n, err)
+	}
+
+	peer.Close()
+	if _, err := conn.Read(b); err != io.EOF {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if _, err := conn.Read(b); !errors.Is(err, net.ErrClosed) {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write(b); !errors.Is(err, net.ErrClosed) {
+		t.Fatal(err)
+	}
+	if err := conn.Close(); !errors.Is(err, net.ErrClosed) {
+		t.Fatal(err)
+	}
+}
+
+func TestTunnelConnCloseWrite(t *testing.T) {
+	c := dialTest(t, directTCPServer)
+	defer c.Close()
+	conn, peer := dialTunnelPair(t, c)
+	defer conn.Close()
+
+	conn.Write([]byte("request"))
+	if err := conn.CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte("more")); !errors.Is(err, io.ErrClosedPipe) {
+		t.Fatal(err)
+	}
+
+	// the peer reads everything written, then EOF, and can still answer
+	b, err := io.ReadAll(peer)
+	if (string(b) != "request") || (err != nil) {
+		t.Fatal(string(b), err)
+	}
+	peer.Write([]byte("response"))
+	peer.Close()
+	if b, err = io.ReadAll(conn); (string(b) != "response") || (err != nil) {
+		t.Fatal(string(b), err)
+	}
+}
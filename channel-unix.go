@@ -0,0 +1,53 @@
+package ssh
+
+import (
+	"context"
+	"net"
+)
+
+// Connect to the Unix domain socket at path on the server
+// (direct-streamlocal@openssh.com).
+func (c *Client) DialUnix(path string) (*TunnelConn, error) {
+	return c.dialUnix(context.Background(), path)
+}
+
+func (c *Client) dialUnix(ctx context.Context, path string) (*TunnelConn, error) {
+	data := NewEncoder().U32String(path).U32String("").U32(0).Out()
+	return c.dialTunnel(ctx, "direct-streamlocal@openssh.com", data, &net.UnixAddr{Net: "unix"}, &net.UnixAddr{Name: path, Net: "unix"})
+}
+
+// Ask the server to listen on the Unix domain socket at path and forward
+// connections to the returned listener (streamlocal-forward@openssh.com).
+func (c *Client) ListenUnix(path string) (net.Listener, error) {
+	l := &forwardListener{client: c, conns: make(chan net.Conn, 16), closed: make(chan int)}
+	err := c.listen("streamlocal-forward@openssh.com", NewEncoder().U32String(path).Out(), func(data []byte) {
+		l.key = unixForwardKey(path)
+		l.addr = &net.UnixAddr{Name: path, Net: "unix"}
+		l.cancel = "cancel-streamlocal-forward@openssh.com"
+		l.cancelData = NewEncoder().U32String(path).Out()
+		c.addForward(l, "forwarded-streamlocal@openssh.com", c.acceptForwardedUnix)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func unixForwardKey(path string) string {
+	return "unix " + path
+}
+
+func (c *Client) acceptForwardedUnix(data []byte) (*Channel, error) {
+	var path, reserved string
+	NewDecoder(data).U32String(&path).U32String(&reserved)
+
+	l := c.forwards[unixForwardKey(path)]
+	if l == nil {
+		return nil, &OpenChannelError{OpenAdministrativelyProhibited, "no forwarding for " + path}
+	}
+
+	conn := newTunnelConn(c)
+	conn.laddr = &net.UnixAddr{Name: path, Net: "unix"}
+	conn.raddr = &net.UnixAddr{Net: "unix"}
+	return l.deliver(conn)
+}
@@ -0,0 +1,206 @@
+package ssh
+
+import (
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	xssh "golang.org/x/crypto/ssh"
+)
+
+// Connect direct-streamlocal channels to their sockets and serve
+// streamlocal-forward requests with forwarded-streamlocal channels. The
+// names of received cancel requests are sent to cancels.
+func unixServer(cancels chan<- string) serverHandler {
+	return func(sc *xssh.ServerConn, chans <-chan xssh.NewChannel, reqs <-chan *xssh.Request) {
+		var mutex sync.Mutex
+		listeners := map[string]net.Listener{}
+		defer func() {
+			for _, ln := range listeners {
+				ln.Close()
+			}
+		}()
+		go func() {
+			for nc := range chans {
+				var d struct {
+					Path     string
+					Reserved string
+					Port     uint32
+				}
+				if (nc.ChannelType() != "direct-streamlocal@openssh.com") || (xssh.Unmarshal(nc.ExtraData(), &d) != nil) {
+					nc.Reject(xssh.Prohibited, "no channels")
+					continue
+				}
+				conn, err := net.Dial("unix", d.Path)
+				if err != nil {
+					nc.Reject(xssh.ConnectionFailed, err.Error())
+					continue
+				}
+				ch, creqs, _ := nc.Accept()
+				go xssh.DiscardRequests(creqs)
+				go pipeChannel(ch, conn)
+			}
+		}()
+
+		for r := range reqs {
+			var path struct{ Path string }
+			xssh.Unmarshal(r.Payload, &path)
+
+			switch r.Type {
+			case "streamlocal-forward@openssh.com":
+				ln, err := net.Listen("unix", path.Path)
+				if err != nil {
+					r.Reply(false, nil)
+					continue
+				}
+				mutex.Lock()
+				listeners[path.Path] = ln
+				mutex.Unlock()
+				r.Reply(true, nil)
+				go func() {
+					for {
+						conn, err := ln.Accept()
+						if err != nil {
+							return
+						}
+						ch, creqs, err := sc.OpenChannel("forwarded-streamlocal@openssh.com", xssh.Marshal(struct {
+							Path     string
+							Reserved string
+						}{path.Path, ""}))
+						if err != nil {
+							conn.Close()
+							continue
+						}
+						go xssh.DiscardRequests(creqs)
+						go pipeChannel(ch, conn)
+					}
+				}()
+
+			case "cancel-streamlocal-forward@openssh.com":
+				mutex.Lock()
+				ln := listeners[path.Path]
+				delete(listeners, path.Path)
+				mutex.Unlock()
+				if ln != nil {
+					ln.Close()
+				}
+				r.Reply(ln != nil, nil)
+				cancels <- r.Type
+
+			default:
+				r.Reply(false, nil)
+			}
+		}
+	}
+}
+
+func TestDialUnix(t *testing.T) {
+	c := dialTest(t, unixServer(make(chan string, 4)))
+	defer c.Close()
+
+	path := filepath.Join(t.TempDir(), "s")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			echo(conn)
+		}
+	}()
+
+	conn, err := c.DialUnix(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if addr := conn.RemoteAddr(); (addr.Network() != "unix") || (addr.String() != path) {
+		t.Fatal(addr)
+	}
+	conn.Write([]byte("hello"))
+	b := make([]byte, 5)
+	if _, err := io.ReadFull(conn, b); (err != nil) || (string(b) != "hello") {
+		t.Fatal(string(b), err)
+	}
+
+	var oe *OpenChannelError
+	if _, err := c.DialUnix(path + ".missing"); !errors.As(err, &oe) || (oe.Reason != OpenConnectFailed) {
+		t.Fatal(err)
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	cancels := make(chan string, 4)
+	c := dialTest(t, unixServer(cancels))
+	defer c.Close()
+
+	path := filepath.Join(t.TempDir(), "s")
+	l, err := c.ListenUnix(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr := l.Addr(); (addr.Network() != "unix") || (addr.String() != path) {
+		t.Fatal(addr)
+	}
+
+	peer, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.LocalAddr().String() != path {
+		t.Fatal(conn.LocalAddr())
+	}
+	peer.Write([]byte("ping"))
+	b := make([]byte, 4)
+	if _, err := io.ReadFull(conn, b); (err != nil) || (string(b) != "ping") {
+		t.Fatal(string(b), err)
+	}
+	conn.Write([]byte("pong"))
+	if _, err := io.ReadFull(peer, b); (err != nil) || (string(b) != "pong") {
+		t.Fatal(string(b), err)
+	}
+	conn.Close()
+
+	// Close cancels the forwarding and unblocks Accept
+	accepted := make(chan error)
+	go func() {
+		_, err := l.Accept()
+		accepted <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-accepted; !errors.Is(err, net.ErrClosed) {
+		t.Fatal(err)
+	}
+	select {
+	case name := <-cancels:
+		if name != "cancel-streamlocal-forward@openssh.com" {
+			t.Fatal(name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no cancel request")
+	}
+	if _, err := net.Dial("unix", path); err == nil {
+		t.Fatal("server still listening")
+	}
+
+	// the path can be forwarded again
+	l, err = c.ListenUnix(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+}
@@ -0,0 +1,155 @@
+package ssh
+
+import (
+	"errors"
+)
+
+// Receive flow control (RFC4254/5.2). The server may send what it was
+// granted; bytes are granted again once consumed, by reads from the buffer of
+// a Session or TunnelConn, when such a sink drops them, or on delivery to
+// other sinks, so unread data never exceeds the window size.
+
+// Receive window of a channel unless ClientOption.ChannelWindow is set.
+const DefaultChannelWindow = 2 * 1024 * 1024
+
+// Largest window auto-tuning grows to.
+const maxChannelWindow = 1 << 30
+
+// Take the window sizes from the client options unless set, from the client
+// loop before the window is announced.
+func (ch *Channel) initWindow() {
+	o := ch.client.option
+	if ch.localWindow == 0 {
+		ch.localWindow = o.ChannelWindow
+	}
+	if ch.localWindow == 0 {
+		ch.localWindow = DefaultChannelWindow
+	}
+	if ch.maxWindow == 0 {
+		ch.maxWindow = o.MaxChannelWindow
+	}
+	if ch.maxWindow > maxChannelWindow {
+		ch.maxWindow = maxChannelWindow
+	}
+	if ch.maxWindow < ch.localWindow {
+		ch.maxWindow = ch.localWindow
+	}
+	ch.available = ch.localWindow
+}
+
+// Resize the receive window. With max above size the window doubles, up to
+// max, while it limits the throughput, see sampleBDP. A larger window is
+// granted at once; a smaller one applies as the buffered data is consumed.
+func (ch *Channel) SetWindow(size, max uint32) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.New("connection closed")
+		}
+	}()
+
+	if max > maxChannelWindow {
+		max = maxChannelWindow
+	}
+	if max < size {
+		max = size
+	}
+	ch.client.do(func() {
+		ch.localWindow, ch.maxWindow = size, max
+		ch.grantWindow()
+	})
+	return
+}
+
+// Account data from the server, from the client loop.
+func (ch *Channel) received(n int) {
+	if uint32(n) > ch.available {
+		Log(5, "channel %v: server sent %v bytes beyond the window", ch.localId, uint32(n)-ch.available)
+		ch.available = 0
+	} else {
+		ch.available -= uint32(n)
+	}
+	ch.buffered += uint32(n)
+
+	if ch.localWindow < ch.maxWindow {
+		ch.sampleBDP(uint32(n))
+	}
+	if !ch.bufferedReads {
+		ch.release(uint32(n))
+	}
+}
+
+// Account n bytes read by the application.
+func (ch *Channel) consume(n int) {
+	defer func() {
+		recover()
+	}()
+
+	ch.client.do(func() {
+		ch.release(uint32(n))
+	})
+}
+
+// Account data a sink dropped rather than buffered, from the client loop.
+func (ch *Channel) discard(n int) {
+	if ch.bufferedReads {
+		ch.release(uint32(n))
+	}
+}
+
+func (ch *Channel) release(n uint32) {
+	if n > ch.buffered {
+		n = ch.buffered
+	}
+	ch.buffered -= n
+	ch.grantWindow()
+}
+
+// Send MsgChannelWindowAdjust once half of the window is free again, from
+// the client loop.
+func (ch *Channel) grantWindow() {
+	if !ch.opened {
+		return
+	}
+
+	used := ch.available + ch.buffered
+	if used >= ch.localWindow {
+		return
+	}
+	grant := ch.localWindow - used
+	if grant < ch.localWindow/2 {
+		return
+	}
+	ch.available += grant
+	ch.client.Packet().Byte(MsgChannelWindowAdjust).U32(ch.remoteId).U32(grant).Commit()
+}
+
+// Estimate the bandwidth-delay product as the data received during the round
+// trip of a keepalive request, as gRPC does with pings. While that comes
+// close to the window, the window is what limits the throughput: double it.
+func (ch *Channel) sampleBDP(n uint32) {
+	ch.bdpSample += n
+	if ch.bdpPending {
+		return
+	}
+
+	ch.bdpPending = true
+	ch.bdpSample = n
+	err := ch.client.sendRequest("keepalive@openssh.com", nil, func(ok bool, data []byte, err error) {
+		ch.bdpPending = false
+		if (err != nil) || !ch.opened || (ch.bdpSample < ch.localWindow/3*2) || (ch.localWindow >= ch.maxWindow) {
+			return
+		}
+
+		size := ch.localWindow * 2
+		if size > ch.maxWindow {
+			size = ch.maxWindow
+		}
+		Log(20, "channel %v: window %v -> %v", ch.localId, ch.localWindow, size)
+		ch.localWindow = size
+		ch.grantWindow()
+	})
+	if err != nil {
+		// no reply will come; sample again with the next data
+		ch.bdpPending = false
+	}
+}
@@ -0,0 +1,60 @@
+package ssh
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	xssh "golang.org/x/crypto/ssh"
+)
+
+// Extended data a TunnelConn drops still frees the window for stdout.
+func TestWindowDiscardedData(t *testing.T) {
+	handle := func(sc *xssh.ServerConn, chans <-chan xssh.NewChannel, reqs <-chan *xssh.Request) {
+		go xssh.DiscardRequests(reqs)
+		for nc := range chans {
+			ch, creqs, _ := nc.Accept()
+			go xssh.DiscardRequests(creqs)
+			go func() {
+				ch.Stderr().Write(make([]byte, 256*1024))
+				ch.Write([]byte("done"))
+				ch.Close()
+			}()
+		}
+	}
+	option := testPassword("p")
+	option.ChannelWindow = 32 * 1024
+	c, err := NewClient(testServer(t, nil, handle), option)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	conn, err := c.DialTCP("127.0.0.1", 9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	b, err := io.ReadAll(conn)
+	if !bytes.Equal(b, []byte("done")) || (err != nil) {
+		t.Fatalf("%q %v", b, err)
+	}
+}
+
+// A keepalive that could not be sent does not stop later samples.
+func TestSampleBDPSendFailure(t *testing.T) {
+	a, b := net.Pipe()
+	a.Close()
+	b.Close()
+	ch := &Channel{client: &Client{transport: rawTransport(a)}, opened: true, localWindow: 1024, maxWindow: 4096}
+	ch.sampleBDP(512)
+	if ch.bdpPending {
+		t.Fatal("sample pending after the keepalive failed")
+	}
+	if n := len(ch.client.globalReplies); n != 0 {
+		t.Fatal(n, "replies pending")
+	}
+}
@@ -1,10 +1,23 @@
 package ssh
 
 import (
+    "context"
     "errors"
+    "fmt"
+    "io"
+    "net"
+    "os"
+    "sync"
+    "time"
 )
 
+// Bytes Write queues beyond the remote window before it blocks.
+const ChannelWriteBuffer = 256*1024
+
+var errChannelClosed = errors.New("channel closed by server")
+
 type ChannelSink struct {
+	OnChannelRequest func(ch *Channel, name string, wantReply bool, data []byte) bool
 	OnChannelFailure func(ch *Channel)
 	OnChannelClose func(ch *Channel)
 	OnChannelEOF func(ch *Channel)
@@ -20,9 +33,105 @@ type Channel struct {
 
 	localId, localWindow, remoteId, remoteWindow, remoteMaxPacketSize uint32
 
+	// Receive window, see channel-window.go: the size can grow up to
+	// maxWindow, available is what the server may still send and buffered
+	// what it sent that was not consumed yet.
+	maxWindow, available, buffered uint32
+
+	// Auto-tuning: a keepalive request is pending and the data received
+	// since it was sent.
+	bdpPending bool
+	bdpSample uint32
+
+	// Data is consumed by reads from a chanBuffer, not on delivery.
+	bufferedReads bool
+
 	opened bool
-	received uint32
+	eofPending bool
+
+	// MsgChannelClose was sent; the channel is freed once the server's
+	// arrives.
+	closeSent bool
+
+	// Data written but not yet sent, shared by writers and the client
+	// loop. Once writeErr is set writes fail with it; unsent counts the
+	// bytes dropped as the channel could no longer send them.
+	writeMutex sync.Mutex
+	writeCond *sync.Cond
 	sending []byte
+	unsent int
+	writeErr error
+	writeDeadline condDeadline
+
+	// Reply handlers of pending want-reply requests, oldest first.
+	replies []func(ok bool, err error)
+
+	// The server allows client side flow control ("xon-xoff").
+	xonXoff bool
+
+	// Signalled with the result of openChannel.
+	openResult chan error
+
+	// openChannel gave up waiting for the server's answer.
+	abandoned bool
+}
+
+// Reason codes of MsgChannelOpenFailure (RFC4254/5.1).
+const (
+	OpenAdministrativelyProhibited = 1
+	OpenConnectFailed              = 2
+	OpenUnknownChannelType         = 3
+	OpenResourceShortage           = 4
+)
+
+// The server refused to open a channel.
+type OpenChannelError struct {
+	Reason uint32
+	Message string
+}
+
+func (e *OpenChannelError) Error() string {
+	return fmt.Sprintf("open channel failure: %v (reason %v)", e.Message, e.Reason)
+}
+
+// Register ch, send MsgChannelOpen with the type specific data and wait until
+// the server confirms or refuses the channel, or ctx is done. A channel given
+// up on is closed as soon as the server confirms it, freeing its slot.
+func (c *Client) openChannel(ctx context.Context, chanType string, data []byte, ch *Channel) (err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            err = errors.New("open channel")
+        }
+    }()
+
+    ch.client = c
+    ch.openResult = make(chan error, 1)
+    c.do(func() {
+        ch.initWindow()
+        ch.localId = c.channelAdd(ch)
+        _, err := c.PacketN(len(chanType)+len(data)+16).Byte(MsgChannelOpen).
+            U32String(chanType).U32(ch.localId).U32(ch.localWindow).U32(MaxPacketSize).
+            Bytes(data).
+            Commit()
+        if err != nil {
+            c.channelDel(ch.localId)
+            ch.openResult<- err
+        }
+    })
+
+    select {
+    case err = <-ch.openResult:
+        return
+    case <-ctx.Done():
+    }
+    c.do(func() {
+        if ch.opened {
+            ch.sendClose()
+        } else {
+            ch.abandoned = true
+        }
+    })
+    return ctx.Err()
 }
 
 func (ch *Channel) Client() *Client {
@@ -33,29 +142,95 @@ func (ch *Channel) RemoteId() uint32 {
     return ch.remoteId
 }
 
+func (ch *Channel) lockWrite() {
+    ch.writeMutex.Lock()
+    if ch.writeCond == nil {
+        ch.writeCond = sync.NewCond(&ch.writeMutex)
+        ch.writeDeadline.cond = ch.writeCond
+    }
+}
+
+// Queue data for sending. Write blocks while ChannelWriteBuffer bytes wait
+// for the remote window, and returns the bytes queued so far with the error
+// once the channel is closed or the write deadline passed.
 func (ch *Channel) Write(data []byte) (n int, err error) {
+    ch.lockWrite()
+    defer ch.writeMutex.Unlock()
+
+    for len(data) > 0 {
+        if ch.writeErr != nil {
+            return n, ch.writeErr
+        }
+        if ch.writeDeadline.exceeded() {
+            return n, os.ErrDeadlineExceeded
+        }
+
+        room := ChannelWriteBuffer - len(ch.sending)
+        if room <= 0 {
+            ch.writeCond.Wait()
+            continue
+        }
+        if room > len(data) {
+            room = len(data)
+        }
+
+        kick := len(ch.sending) == 0
+        ch.sending = append(ch.sending, data[:room]...)
+        data = data[room:]
+        n += room
+
+        if kick {
+            // the loop takes writeMutex in sendData
+            ch.writeMutex.Unlock()
+            err = ch.flush()
+            ch.writeMutex.Lock()
+            if err != nil {
+                return
+            }
+        }
+    }
+    return
+}
+
+// Have the client loop send the queued data.
+func (ch *Channel) flush() (err error) {
     defer func() {
         if r := recover(); r != nil {
-            err = errors.New("write")
+            err = errors.New("write: connection closed")
         }
     }()
 
-    n = len(data)
-    cached := make([]byte, len(data))
-    copy(cached, data)
-    if n > 0 {
-        ch.client.do(func() {
-            if len(ch.sending) == 0 {
-                ch.sending = cached
-            } else {
-                ch.sending = append(ch.sending, cached...)
-            }
-            ch.sendData()
-        })
+    ch.client.do(func() {
+        ch.sendData()
+    })
+    return
+}
+
+// Writes waiting past t fail with os.ErrDeadlineExceeded; the zero time
+// disables the deadline.
+func (ch *Channel) setWriteDeadline(t time.Time) {
+    ch.lockWrite()
+    ch.writeDeadline.set(t)
+    ch.writeMutex.Unlock()
+}
+
+// Fail pending and later writes with err, unless they already fail, and drop
+// the data that can no longer be sent.
+func (ch *Channel) shutdownWrite(err error) {
+    ch.lockWrite()
+    if ch.writeErr == nil {
+        ch.writeErr = err
     }
-	return
+    ch.unsent += len(ch.sending)
+    ch.sending = nil
+    ch.writeCond.Broadcast()
+    ch.writeMutex.Unlock()
 }
 
+// Close the channel once the data written so far is sent, waiting for the
+// remote window until the write deadline passes or the connection ends.
+// Pending and later writes fail with net.ErrClosed. Close reports data that
+// could not be sent.
 func (ch *Channel) Close() (err error) {
     defer func() {
         if r := recover(); r != nil {
@@ -63,9 +238,31 @@ func (ch *Channel) Close() (err error) {
         }
     }()
 
+    ch.lockWrite()
+    ch.writeErr = net.ErrClosed
+    ch.writeCond.Broadcast()
+    for (len(ch.sending) > 0) && !ch.writeDeadline.exceeded() {
+        ch.writeCond.Wait()
+    }
+    ch.writeMutex.Unlock()
+
+    closed := make(chan int, 1)
     ch.client.do(func() {
         ch.sendClose()
+        ch.shutdownWrite(net.ErrClosed)
+        closed<- 0
     })
+    select {
+    case <-closed:
+    case <-ch.client.done:
+    }
+
+    ch.lockWrite()
+    unsent := ch.unsent + len(ch.sending)
+    ch.writeMutex.Unlock()
+    if unsent > 0 {
+        err = fmt.Errorf("close: %v bytes not sent", unsent)
+    }
     return
 }
 
@@ -73,11 +270,13 @@ func (ch *Channel) sendClose() (err error) {
     if ch.opened {
         ch.sendData()
         ch.opened = false
+        ch.closeSent = true
         _, err = ch.client.Packet().Byte(MsgChannelClose).U32(ch.remoteId).Commit()
     }
     return
 }
 
+// Send queued data as far as the remote window allows, from the client loop.
 func (ch *Channel) sendData() (err error) {
 	if !ch.opened {
 	    err = errors.New("cannot send on closed channel")
@@ -85,40 +284,66 @@ func (ch *Channel) sendData() (err error) {
 		return
 	}
 
-	if ch.remoteWindow == 0 {
-	    return
-	}
+	ch.lockWrite()
+	for (len(ch.sending) > 0) && (ch.remoteWindow > 0) && (err == nil) {
+		n := len(ch.sending)
+		if m := int(ch.remoteMaxPacketSize-9); n > m {
+			n = m
+		}
+		if m := int(ch.remoteWindow); n > m {
+			n = m
+		}
 
-	n := len(ch.sending)
-	if n == 0 {
-		return
-	}
+		data := ch.sending[:n]
+		if ch.sending = ch.sending[n:]; len(ch.sending) == 0 {
+		    ch.sending = nil
+		}
+		ch.writeCond.Broadcast()
+		ch.writeMutex.Unlock()
 
-	if m := int(ch.remoteMaxPacketSize-9); n > m {
-	    n = m
+		ch.remoteWindow -= uint32(n)
+		_, err = ch.client.Packet().Byte(MsgChannelData).U32(ch.remoteId).U32Bytes(data).Commit()
+		ch.lockWrite()
 	}
+	empty := len(ch.sending) == 0
+	ch.writeMutex.Unlock()
 
-	if m := int(ch.remoteWindow); n > m {
-	    n = m
+	if err != nil {
+	    ch.shutdownWrite(err)
+	}
+	if (err == nil) && empty && ch.eofPending {
+		ch.eofPending = false
+		_, err = ch.client.Packet().Byte(MsgChannelEOF).U32(ch.remoteId).Commit()
 	}
-
-    ch.remoteWindow -= uint32(n)
-	_, err = ch.client.Packet().Byte(MsgChannelData).U32(ch.remoteId).U32Bytes(ch.sending[:n]).Commit()
-	ch.sending = ch.sending[n:]
 	return
 }
 
-func (ch *Channel) updateWindow(data []byte) {
-    ch.received += uint32(len(data))
-}
+// Send MsgChannelEOF once the data written so far is sent, telling the
+// server there is no more input. The channel still receives data until the
+// server closes it. Later writes fail with io.ErrClosedPipe.
+func (ch *Channel) CloseWrite() (err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            err = errors.New("close write")
+        }
+    }()
 
-func (ch *Channel) moreData() {
-    ch.client.do(func() {
-        if ch.opened && (ch.localWindow < (ch.received + MaxPacketSize)) {
-            delta := ch.received
-            ch.received = 0
+    ch.lockWrite()
+    first := ch.writeErr == nil
+    if first {
+        ch.writeErr = io.ErrClosedPipe
+        ch.writeCond.Broadcast()
+    }
+    ch.writeMutex.Unlock()
+    if !first {
+        return
+    }
 
-            ch.client.Packet().Byte(MsgChannelWindowAdjust).U32(ch.remoteId).U32(delta).Commit()
+    ch.client.do(func() {
+        if ch.opened {
+            ch.eofPending = true
+            ch.sendData()
         }
     })
+    return
 }
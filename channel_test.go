@@ -0,0 +1,122 @@
+package ssh
+
+import (
+	"bytes"
+	crand "crypto/rand"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// Close sends the data still waiting for the remote window before the close.
+func TestChannelCloseFlush(t *testing.T) {
+	c, server := rawClient(t)
+
+	dialed := make(chan *TunnelConn)
+	go func() {
+		conn, err := c.DialTCP("127.0.0.1", 9)
+		if err != nil {
+			t.Error(err)
+		}
+		dialed <- conn
+	}()
+	id := confirmOpen(t, server, 0)
+	conn := <-dialed
+	if conn == nil {
+		t.FailNow()
+	}
+
+	data := make([]byte, ChannelWriteBuffer+44*1024)
+	crand.Read(data)
+	written := make(chan error)
+	go func() {
+		_, err := conn.Write(data)
+		written <- err
+	}()
+	select {
+	case err := <-written:
+		t.Fatal("Write did not wait for the window", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// a first grant lets Write queue the rest
+	grant := func(n uint32) {
+		server.Packet().Byte(MsgChannelWindowAdjust).U32(id).U32(n).Commit()
+	}
+	grant(64 * 1024)
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+
+	closed := make(chan error)
+	go func() { closed <- conn.Close() }()
+	var got []byte
+	for received := uint32(0); ; {
+		if received == 64*1024 {
+			select {
+			case err := <-closed:
+				t.Fatal("Close did not wait for the window", err)
+			case <-time.After(100 * time.Millisecond):
+			}
+			grant(uint32(len(data)) - received)
+		}
+
+		p, err := server.readPacket()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p[0] == MsgChannelClose {
+			break
+		}
+		if p[0] != MsgChannelData {
+			t.Fatal("unexpected message", p[0])
+		}
+		var chunk []byte
+		NewDecoder(p[1:]).U32(new(uint32)).U32Bytes(&chunk)
+		got = append(got, chunk...)
+		received += uint32(len(chunk))
+	}
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("sent", len(got), "of", len(data), "bytes")
+	}
+	if _, err := conn.Write([]byte("x")); !errors.Is(err, net.ErrClosed) {
+		t.Fatal(err)
+	}
+}
+
+// With the window held at zero, writes and Close give up at the write
+// deadline, and Close reports the data left unsent.
+func TestChannelCloseDeadline(t *testing.T) {
+	c, server := rawClient(t)
+
+	dialed := make(chan *TunnelConn)
+	go func() {
+		conn, _ := c.DialTCP("127.0.0.1", 9)
+		dialed <- conn
+	}()
+	confirmOpen(t, server, 0)
+	conn := <-dialed
+	if conn == nil {
+		t.FailNow()
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+	n, err := conn.Write(make([]byte, ChannelWriteBuffer+1))
+	if (n != ChannelWriteBuffer) || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatal(n, err)
+	}
+	if err := conn.Close(); err == nil {
+		t.Fatal("Close reported no unsent data")
+	}
+	if p, err := server.readPacket(); (err != nil) || (p[0] != MsgChannelClose) {
+		t.Fatal(p, err)
+	}
+	if _, err := conn.Write([]byte("x")); !errors.Is(err, net.ErrClosed) {
+		t.Fatal(err)
+	}
+}
@@ -24,6 +24,16 @@ func (c *ClientChannels) channelDel(id uint32) {
     }
 }
 
+// Some channel waits for the server to confirm its close.
+func (c *ClientChannels) closesPending() bool {
+    for _, ch := range c.channels {
+        if (ch != nil) && ch.closeSent {
+            return true
+        }
+    }
+    return false
+}
+
 func (c *ClientChannels) channelGet(id uint32) (ch *Channel) {
     return c.channels[id]
 }
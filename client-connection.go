@@ -10,6 +10,27 @@ func (c *Client) handlePacket(packet []byte) {
     case MsgIgnore:
         Log(20, "Ignoring MsgIgnore")
 
+    case MsgGlobalRequest:
+        var name string
+        var wantReply byte
+        var data []byte
+        NewDecoder(packetData).U32String(&name).Byte(&wantReply).Rest(&data)
+
+        Log(5, "MsgGlobalRequest %v", name)
+        c.handleGlobalRequest(name, wantReply != 0, data)
+
+    case MsgRequestSuccess, MsgRequestFailure:
+        c.handleReply(code == MsgRequestSuccess, packetData)
+
+    case MsgChannelOpen:
+        var chanType string
+        var remoteId, remoteWindow, remoteMaxPacketSize uint32
+        var data []byte
+        NewDecoder(packetData).U32String(&chanType).U32(&remoteId).U32(&remoteWindow).U32(&remoteMaxPacketSize).Rest(&data)
+
+        Log(5, "MsgChannelOpen %v %v", chanType, remoteId)
+        c.acceptChannel(chanType, remoteId, remoteWindow, remoteMaxPacketSize, data)
+
     case MsgChannelWindowAdjust:
         var localId, wadj uint32
         NewDecoder(packetData).U32(&localId).U32(&wadj).End()
@@ -25,7 +46,9 @@ func (c *Client) handlePacket(packet []byte) {
 
         ch := c.channelGet(localId)
         c.channelDel(localId)
+        ch.shutdownWrite(errChannelClosed)
         ch.sendClose()
+        ch.dropReplies()
         if ch.OnChannelClose != nil {
             ch.OnChannelClose(ch)
         }
@@ -40,13 +63,39 @@ func (c *Client) handlePacket(packet []byte) {
         }
 
     case MsgChannelRequest:
-        Log(20, "Ignoring MsgChannelRequest")
+        var localId uint32
+        var name string
+        var wantReply byte
+        var data []byte
+        NewDecoder(packetData).U32(&localId).U32String(&name).Byte(&wantReply).Rest(&data)
+
+        Log(5, "MsgChannelRequest %v %v", localId, name)
+        ch := c.channelGet(localId)
+        ok := ch.handleRequest(name, wantReply != 0, data)
+        if (wantReply != 0) && ch.opened {
+            reply := byte(MsgChannelFailure)
+            if ok {
+                reply = MsgChannelSuccess
+            }
+            c.Packet().Byte(reply).U32(ch.remoteId).Commit()
+        }
 
     case MsgChannelSuccess:
-        Log(20, "Ignoring MsgChannelSuccess")
+        var localId uint32
+        NewDecoder(packetData).U32(&localId).End()
+
+        ch := c.channelGet(localId)
+        ch.handleReply(true)
 
     case MsgChannelFailure:
-        Log(20, "Ignoring MsgChannelFailure")
+        var localId uint32
+        NewDecoder(packetData).U32(&localId).End()
+
+        ch := c.channelGet(localId)
+        ch.handleReply(false)
+        if ch.OnChannelFailure != nil {
+            ch.OnChannelFailure(ch)
+        }
 
     case MsgChannelData:
         var localId uint32
@@ -54,7 +103,7 @@ func (c *Client) handlePacket(packet []byte) {
         NewDecoder(packetData).U32(&localId).U32Bytes(&data).End()
 
         ch := c.channelGet(localId)
-        ch.updateWindow(data)
+        ch.received(len(data))
         if ch.OnChannelData != nil {
             ch.OnChannelData(ch, data)
         }
@@ -65,7 +114,7 @@ func (c *Client) handlePacket(packet []byte) {
         NewDecoder(packetData).U32(&localId).U32(&dataType).U32Bytes(&data).End()
 
         ch := c.channelGet(localId)
-        ch.updateWindow(data)
+        ch.received(len(data))
         if ch.OnChannelExtendedData != nil {
             ch.OnChannelExtendedData(ch, data, dataType)
         }
@@ -82,10 +131,17 @@ func (c *Client) handlePacket(packet []byte) {
         ch.remoteId = remoteId
         ch.remoteWindow = remoteWindow
         ch.remoteMaxPacketSize = remoteMaxPacketSize
+        if ch.abandoned {
+            ch.sendClose()
+            break
+        }
 
         if ch.OnChannelOpenConfirmation != nil {
             ch.OnChannelOpenConfirmation(ch)
         }
+        if ch.openResult != nil {
+            ch.openResult<- nil
+        }
 
     case MsgChannelOpenFailure:
         var localId, reason uint32
@@ -97,6 +153,9 @@ func (c *Client) handlePacket(packet []byte) {
         if ch.OnChannelOpenFailure != nil {
             ch.OnChannelOpenFailure(ch, reason, description, lang)
         }
+        if ch.openResult != nil {
+            ch.openResult<- &OpenChannelError{reason, description}
+        }
 
     case MsgKexinit:
         Log(20, "MsgKexinit")
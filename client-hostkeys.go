@@ -0,0 +1,65 @@
+package ssh
+
+import (
+)
+
+// Handle the hostkeys-00@openssh.com announcement (PROTOCOL section 2.5 in
+// the OpenSSH sources): ask the server to prove it holds the private keys and
+// pass the proven set to ClientOption.UpdateHostKeys.
+func (c *Client) handleHostKeys(data []byte) {
+	if c.option.UpdateHostKeys == nil {
+		return
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			Log(5, "hostkeys: %v", r)
+		}
+	}()
+
+	var keys []PublicKey
+	prove := NewEncoder()
+	for p := NewDecoder(data); !p.IsEnd(); {
+		var blob []byte
+		p.U32Bytes(&blob)
+
+		key, err := ParsePublicKey(blob)
+		if err != nil {
+			Log(20, "hostkeys: skipping key: %v", err)
+			continue
+		}
+		keys = append(keys, key)
+		prove.U32Bytes(blob)
+	}
+	if len(keys) == 0 {
+		return
+	}
+
+	c.sendRequest("hostkeys-prove-00@openssh.com", prove.Out(), func(ok bool, data []byte, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				Log(5, "hostkeys: malformed proof: %v", r)
+			}
+		}()
+
+		if !ok {
+			Log(5, "hostkeys: server refused to prove its host keys %v", err)
+			return
+		}
+
+		p := NewDecoder(data)
+		for _, key := range keys {
+			var sig []byte
+			p.U32Bytes(&sig)
+
+			signed := NewEncoder().U32String("hostkeys-prove-00@openssh.com").U32Bytes(c.session_id).U32Bytes(key.Marshal()).Out()
+			if err := key.Verify(signed, sig); err != nil {
+				Log(5, "hostkeys: proof for %v failed: %v", FingerprintSHA256(key), err)
+				return
+			}
+		}
+		p.End()
+
+		go c.option.UpdateHostKeys(c.option.Host, keys)
+	})
+}
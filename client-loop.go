@@ -1,21 +1,49 @@
 package ssh
 
-func (c *Client) startLoop() {
+import (
+    "errors"
+)
+
+// Start the loops. loop1 runs the handshake before anything else, so that it
+// reads the server's first packets rather than handlePacket.
+func (c *Client) startLoop(handshake func()) {
     c.wait.Add(1); defer c.wait.Done()
 
 	Log(6, "Starting client loop")
 	c.actions = make(chan func(), 256*1024)
+	c.done = make(chan int)
 	c.channels = make([]*Channel, 0, 1024)
 
     c.wait.Add(2)
-	c.reading = true
 
-	go c.loop1()
+	go c.loop1(handshake)
 	go c.loop2()
 }
 
+// Returned by requests on a client whose connection is closed.
+var ErrClientClosed = errors.New("connection closed")
+
+// Queue an action for loop1. Once the loop ended this panics, which callers
+// recover from as the connection being closed.
 func (c *Client) do(action func()) {
-    c.actions<- action
+    select {
+    case <-c.done:
+        panic(ErrClientClosed)
+    default:
+    }
+    select {
+    case c.actions<- action:
+    case <-c.done:
+        panic(ErrClientClosed)
+    }
+}
+
+// Ask loop1 to exit, unless it already did, e.g. as the connection ended.
+func (c *Client) stop() {
+    select {
+    case c.actions<- nil:
+    case <-c.done:
+    }
 }
 
 func (c *Client) getRequest() (action func()) {
@@ -23,16 +51,23 @@ func (c *Client) getRequest() (action func()) {
     return
 }
 
-func (c *Client) loop1() {
+func (c *Client) loop1(handshake func()) {
     defer func() {
         if r := recover(); r != nil {
+            // e.g. a malformed packet; nothing is answered anymore
             Log(10, "panic: loop() %v", r)
+            c.conn.Close()
         }
+        close(c.done)
+        c.dropAll()
 
         Log(10, "loop() exit")
         c.wait.Done()
     }()
 
+    handshake()
+
+    reading := true
 	for running := true; running; {
 	    select {
         case action := <-c.actions:
@@ -46,7 +81,7 @@ func (c *Client) loop1() {
             if packet != nil {
                 c.handlePacket(packet)
             } else {
-                running = false
+                running, reading = false, false
             }
         }
 	}
@@ -62,27 +97,58 @@ func (c *Client) loop1() {
             }
         }
 
+        // wait for the server to confirm the closes, not for opens it did
+        // not answer; its replies are packets
         if err == nil {
-            for c.reading && (c.channelCount > 0) {
-                request := c.getRequest()
-                if request == nil { break }
-                request()
+        drain:
+            for reading && c.closesPending() {
+                select {
+                case action := <-c.actions:
+                    if action == nil { break drain }
+                    action()
+
+                case packet := <-c.packets:
+                    if packet == nil { break drain }
+                    c.handlePacket(packet)
+                }
             }
         }
 
-        close(c.actions)
         c.conn.Close()
     }
 }
 
+// The connection is gone, so are its channels: fail everything waiting for
+// the server, once loop1 ended.
+func (c *Client) dropAll() {
+    c.dropReplies()
+    c.dropForwards()
+    for _, ch := range c.channels {
+        if ch != nil {
+            ch.shutdownWrite(errors.New("connection closed"))
+            ch.dropReplies()
+            if ch.openResult != nil {
+                select {
+                case ch.openResult<- errors.New("connection closed"):
+                default:
+                }
+            }
+            if ch.OnChannelClose != nil {
+                ch.OnChannelClose(ch)
+            }
+        }
+    }
+}
+
 func (c *Client) loop2() {
     defer func() {
         if r := recover(); r != nil {
             Log(10, "panic: loop2() %v", r)
         }
 
+        // the end of the connection, also after a panic
+        c.packets<- nil
         Log(10, "loop2() exit")
-        c.reading = false
         c.wait.Done()
     }()
 
@@ -90,7 +156,6 @@ func (c *Client) loop2() {
         packet, err := c.readPacket()
         if len(packet) == 0 {
             Log(20, "loop2 %v", err)
-            c.packets<- nil
             break
         } else {
             c.packets<- packet
@@ -0,0 +1,116 @@
+package ssh
+
+import (
+	"context"
+)
+
+// Send a global request (RFC4254/4). With wantReply wait for the server's
+// answer and return it with the request specific reply data; replies are
+// matched to requests in order. Once the connection is closed it fails with
+// ErrClientClosed.
+func (c *Client) SendRequest(name string, wantReply bool, payload []byte) (bool, []byte, error) {
+	return c.SendRequestContext(context.Background(), name, wantReply, payload)
+}
+
+// Like SendRequest, giving up waiting when ctx is done. A reply arriving
+// later is dropped.
+func (c *Client) SendRequestContext(ctx context.Context, name string, wantReply bool, payload []byte) (ok bool, reply []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = ErrClientClosed
+		}
+	}()
+
+	type answer struct {
+		ok   bool
+		data []byte
+		err  error
+	}
+	result := make(chan answer, 2)
+	c.do(func() {
+		var reply func(bool, []byte, error)
+		if wantReply {
+			reply = func(ok bool, data []byte, err error) {
+				result <- answer{ok, data, err}
+			}
+		}
+		err := c.sendRequest(name, payload, reply)
+		result <- answer{err == nil, nil, err}
+	})
+
+	for sent := false; ; sent = true {
+		select {
+		case a := <-result:
+			if sent || (a.err != nil) || !wantReply {
+				return a.ok, a.data, a.err
+			}
+		case <-ctx.Done():
+			return false, nil, ctx.Err()
+		}
+	}
+}
+
+// Send a global request from the client loop. A non-nil reply asks for an
+// answer and is called with it, or with an error when the connection ends
+// first.
+func (c *Client) sendRequest(name string, payload []byte, reply func(ok bool, data []byte, err error)) (err error) {
+	p := c.PacketN(len(name)+len(payload)+8).Byte(MsgGlobalRequest).U32String(name)
+	if reply != nil {
+		p.Byte(1)
+	} else {
+		p.Byte(0)
+	}
+	if _, err = p.Bytes(payload).Commit(); err != nil {
+		return
+	}
+
+	if reply != nil {
+		c.globalReplies = append(c.globalReplies, reply)
+	}
+	return
+}
+
+// Deliver MsgRequestSuccess or MsgRequestFailure to the oldest request. A
+// reply nothing waits for is dropped.
+func (c *Client) handleReply(ok bool, data []byte) {
+	if len(c.globalReplies) == 0 {
+		Log(5, "unexpected global request reply")
+		return
+	}
+	reply := c.globalReplies[0]
+	c.globalReplies = c.globalReplies[1:]
+	reply(ok, data, nil)
+}
+
+// Fail the requests still waiting for a reply once the connection ends.
+func (c *Client) dropReplies() {
+	replies := c.globalReplies
+	c.globalReplies = nil
+	for _, reply := range replies {
+		reply(false, nil, ErrClientClosed)
+	}
+}
+
+// Answer a global request from the server. Requests not handled here or by
+// ClientOption.OnGlobalRequest are refused as RFC4254/4 requires.
+func (c *Client) handleGlobalRequest(name string, wantReply bool, data []byte) {
+	ok := false
+	var reply []byte
+	switch {
+	case name == "hostkeys-00@openssh.com":
+		c.handleHostKeys(data)
+		return
+
+	case c.option.OnGlobalRequest != nil:
+		ok, reply = c.option.OnGlobalRequest(name, wantReply, data)
+	}
+
+	if !wantReply {
+		return
+	}
+	if ok {
+		c.PacketN(len(reply) + 1).Byte(MsgRequestSuccess).Bytes(reply).Commit()
+	} else {
+		c.Packet().Byte(MsgRequestFailure).Commit()
+	}
+}
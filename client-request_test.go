@@ -0,0 +1,83 @@
+package ssh
+
+import (
+	"errors"
+	"testing"
+
+	xssh "golang.org/x/crypto/ssh"
+)
+
+func TestClientStrayReply(t *testing.T) {
+	c := dialTest(t, func(sc *xssh.ServerConn, chans <-chan xssh.NewChannel, reqs <-chan *xssh.Request) {
+		go func() {
+			for r := range reqs {
+				r.Reply(r.Type == "ok", []byte(r.Type))
+			}
+		}()
+		for nc := range chans {
+			ch, creqs, _ := nc.Accept()
+			go xssh.DiscardRequests(creqs)
+			go func() {
+				ch.Write([]byte("hi"))
+				ch.Close()
+			}()
+		}
+	})
+	defer c.Close()
+
+	// as if the server answered a request twice
+	c.do(func() {
+		c.handleReply(true, nil)
+	})
+	if ok, reply, err := c.SendRequest("ok", true, nil); !ok || (string(reply) != "ok") || (err != nil) {
+		t.Fatal(ok, reply, err)
+	}
+
+	// the client still works
+	s, err := c.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if ok, _, err := c.SendRequest("other", true, nil); ok || (err != nil) {
+		t.Fatal(ok, err)
+	}
+}
+
+func TestSendRequestClosed(t *testing.T) {
+	hangup := make(chan int)
+	c := dialTest(t, func(sc *xssh.ServerConn, chans <-chan xssh.NewChannel, reqs <-chan *xssh.Request) {
+		go func() {
+			for range reqs {
+				// never answered; the server hangs up instead
+				close(hangup)
+			}
+		}()
+		for nc := range chans {
+			_, creqs, _ := nc.Accept()
+			go xssh.DiscardRequests(creqs)
+		}
+	})
+	defer c.Close()
+	s, err := c.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a request waiting for its reply when the connection ends
+	go func() {
+		<-hangup
+		c.conn.Close()
+	}()
+	if _, _, err := c.SendRequest("hang", true, nil); !errors.Is(err, ErrClientClosed) {
+		t.Fatal(err)
+	}
+
+	c.Close()
+	if _, _, err := c.SendRequest("late", true, nil); !errors.Is(err, ErrClientClosed) {
+		t.Fatal(err)
+	}
+	if _, err := s.ch.SendRequest("late", true, nil); !errors.Is(err, ErrClientClosed) {
+		t.Fatal(err)
+	}
+}
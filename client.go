@@ -1,25 +1,113 @@
 package ssh
 
 import (
+    "context"
     "errors"
+    "fmt"
     "io"
+    "net"
+    "runtime"
     "sync"
+    "time"
 )
 
 type ClientOption struct {
 	User string
-	CheckHostKey func([]byte) error
+
+	// Name of the server, "host" or "host:port", passed to CheckHostKey.
+	Host string
+
+	// Called once the server proved that it holds the host key; an error
+	// aborts the connection.
+	CheckHostKey func(info *HostKeyInfo) error
+
 	GetPassword func() (string, error)
+
+	// Called in a new goroutine with Host and the complete set of host keys
+	// the server proved to hold after it announced them with
+	// hostkeys-00@openssh.com, e.g. to update known_hosts when keys rotate.
+	UpdateHostKeys func(host string, keys []PublicKey)
+
+	// Keys tried with publickey authentication before any password, see
+	// ParsePrivateKey.
+	Signers []Signer
+
+	// Host key for hostbased authentication, sent with the local host name
+	// (os.Hostname() with a trailing dot if empty) and the name of the local
+	// account running the client (the current OS user if empty), which the
+	// server maps to User.
+	HostSigner Signer
+	LocalHostName string
+	LocalUser string
+
+	// Called with the message and language tag of every
+	// SSH_MSG_USERAUTH_BANNER received during authentication.
+	OnBanner func(message, lang string)
+
+	// Answers global requests from the server not handled by the client
+	// itself, returning the reply data for success. Unset, all are refused.
+	// Called from the client loop, so it must not block or send requests.
+	OnGlobalRequest func(name string, wantReply bool, data []byte) (ok bool, reply []byte)
+
+	// Receive window of channels, DefaultChannelWindow if zero. Above it,
+	// MaxChannelWindow enables auto-tuning, see Channel.SetWindow.
+	ChannelWindow, MaxChannelWindow uint32
+
+	// Time Dial may take to connect, exchange keys and authenticate; no
+	// limit if zero.
+	Timeout time.Duration
+
+	// Bastions Dial connects through in order, like ssh -J: each is reached
+	// over a tunnel of the previous one, and addr over the last.
+	JumpHosts []JumpHost
+}
+
+// Server host key details for ClientOption.CheckHostKey.
+type HostKeyInfo struct {
+	Host string
+
+	// Address of the connection, nil if it has no RemoteAddr method.
+	RemoteAddr net.Addr
+
+	Key PublicKey
+
+	// Key type and negotiated host key algorithm.
+	Type, Algorithm string
+
+	// Fingerprints in OpenSSH format, see FingerprintSHA256 and
+	// FingerprintMD5. RandomArt(Key) renders the key for humans.
+	SHA256, MD5 string
 }
 
 type Client struct {
 	ClientChannels
 	*transport
+	option *ClientOption
 
 	actions chan func()
 
+	// Closed when loop1 ended; actions are no longer run.
+	done chan int
+
+	// Answer handlers of sent global requests, in request order.
+	globalReplies []func(ok bool, data []byte, err error)
+
+	// Accept channels the server opens, by channel type; see acceptChannel.
+	channelTypes map[string]func(data []byte) (*Channel, error)
+
+	// Remote listeners by forwardListener.key.
+	forwards map[string]*forwardListener
+
+	x11 x11Cookies
+
+	// Peer of the connection, nil if it has no RemoteAddr method.
+	remoteAddr net.Addr
+
+	// Client of the jump host carrying the connection, closed with this one.
+	jump *Client
+
 	wait sync.WaitGroup
-	reading bool
+	closeOnce sync.Once
 }
 
 func NewClient(conn io.ReadWriteCloser, option *ClientOption) (*Client, error) {
@@ -29,53 +117,125 @@ func NewClient(conn io.ReadWriteCloser, option *ClientOption) (*Client, error) {
 		return nil, err
 	}
 
-	client := &Client{transport: s}
-	client.startLoop()
+	client := &Client{transport: s, option: option}
+	if c, ok := conn.(interface{ RemoteAddr() net.Addr }); ok {
+		client.remoteAddr = c.RemoteAddr()
+	}
 
 	done := make(chan int, 1)
 	ok := false
+	failure := errors.New("failed")
 
-	client.do(func() {
+	handshake := func() {
         defer func() {
+            switch r := recover().(type) {
+            case nil:
+            case runtime.Error:
+                Log(10, "handshake: %v", r)
+                failure = errors.New("malformed packet during handshake")
+            case error:
+                failure = r
+            default:
+                failure = fmt.Errorf("%v", r)
+            }
             done<- 0
         }()
 
         Log(20, "a")
         s.writeKexInit()
 
-        b := <-client.packets
+        b, err := s.kexPacket()
+        if err != nil {
+            failure = err
+            return
+        }
 
         k, err := s.parseKexinit(b)
         Log(20, "%v", k)
         if err != nil {
+            failure = err
             return
         }
 
-        err = s.dh(k, option.CheckHostKey)
+        err = s.dh(k, func(key PublicKey) error {
+            if option.CheckHostKey == nil {
+                return nil
+            }
+            info := &HostKeyInfo{Host: option.Host, Key: key, Type: key.Type(), Algorithm: k.Shk, SHA256: FingerprintSHA256(key), MD5: FingerprintMD5(key)}
+            if c, ok := conn.(interface{ RemoteAddr() net.Addr }); ok {
+                info.RemoteAddr = c.RemoteAddr()
+            }
+            return option.CheckHostKey(info)
+        })
         if err != nil {
+            failure = err
             return
         }
 
-        for option.GetPassword != nil {
-            password, err := option.GetPassword()
-            if err != nil {
+        if (len(option.Signers) > 0) || (option.HostSigner != nil) || (option.GetPassword != nil) {
+            if !client.auth() {
+                failure = errors.New("authentication failed")
                 return
             }
-            if client.auth(option.User, password) {
-                break
-            }
         }
         Log(20, "b")
         ok = true
-    })
+    }
+    client.startLoop(handshake)
     <-done
     if !ok {
-        return nil, errors.New("failed")
+        // stop the loops; loop2 may wait for keys the failed exchange never set
+        conn.Close()
+        select {
+        case client.newkey<- 0:
+        default:
+        }
+        client.Close()
+        return nil, failure
     }
 	return client, nil
 }
 
+// Like NewClient, closing conn to abort the handshake and authentication when
+// ctx is done first.
+func NewClientContext(ctx context.Context, conn io.ReadWriteCloser, option *ClientOption) (*Client, error) {
+    done := make(chan int)
+    aborted := make(chan bool, 1)
+    go func() {
+        select {
+        case <-ctx.Done():
+            conn.Close()
+            aborted<- true
+        case <-done:
+            aborted<- false
+        }
+    }()
+
+    client, err := NewClient(conn, option)
+    close(done)
+    if <-aborted {
+        if client != nil {
+            client.Close()
+        }
+        return nil, ctx.Err()
+    }
+    return client, err
+}
+
+// Address of the server, e.g. the one given to Dial; nil if the connection
+// passed to NewClient has no RemoteAddr method.
+func (c *Client) RemoteAddr() net.Addr {
+    return c.remoteAddr
+}
+
+// Close the connection, then the jump hosts Dial connected through. It is
+// safe after the server hung up, and closing again does nothing.
 func (c *Client) Close() {
-	c.do(nil)
-	c.wait.Wait()
+	c.closeOnce.Do(func() {
+		c.stop()
+		c.wait.Wait()
+		if c.jump != nil {
+			c.jump.Close()
+		}
+	})
 }
@@ -0,0 +1,276 @@
+package ssh
+
+import (
+	"context"
+	"crypto/ed25519"
+	crand "crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	xssh "golang.org/x/crypto/ssh"
+)
+
+type serverHandler func(sc *xssh.ServerConn, chans <-chan xssh.NewChannel, reqs <-chan *xssh.Request)
+
+var testHostKey xssh.Signer
+
+func init() {
+	k, _ := rsa.GenerateKey(crand.Reader, 2048)
+	testHostKey, _ = xssh.NewSignerFromKey(k)
+}
+
+// Serve one connection with an in-process server and return the client end.
+// A nil cfg accepts any password; a nil handle refuses all channels.
+func testServer(t *testing.T, cfg *xssh.ServerConfig, handle serverHandler) net.Conn {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		c, err := ln.Accept()
+		ln.Close()
+		if err != nil {
+			return
+		}
+		serveTest(c, cfg, handle)
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// Accept connections until ln is closed, serving each like testServer.
+func testListener(t *testing.T, cfg func() *xssh.ServerConfig, handle serverHandler) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveTest(c, cfg(), handle)
+		}
+	}()
+	return ln
+}
+
+func serveTest(c net.Conn, cfg *xssh.ServerConfig, handle serverHandler) {
+	if cfg == nil {
+		cfg = &xssh.ServerConfig{PasswordCallback: func(xssh.ConnMetadata, []byte) (*xssh.Permissions, error) {
+			return nil, nil
+		}}
+	}
+	// the client only speaks these with ssh-rsa host keys
+	cfg.KeyExchanges = []string{"diffie-hellman-group14-sha1"}
+	cfg.AddHostKey(testHostKey)
+
+	sc, chans, reqs, err := xssh.NewServerConn(c, cfg)
+	if err != nil {
+		c.Close()
+		return
+	}
+	if handle == nil {
+		go xssh.DiscardRequests(reqs)
+		for nc := range chans {
+			nc.Reject(xssh.Prohibited, "no channels")
+		}
+		return
+	}
+	handle(sc, chans, reqs)
+}
+
+func testPassword(password string) *ClientOption {
+	return &ClientOption{User: "u", GetPassword: func() (string, error) { return password, nil }}
+}
+
+func dialTest(t *testing.T, handle serverHandler) *Client {
+	c, err := NewClient(testServer(t, nil, handle), testPassword("p"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// A client past key exchange and authentication on a null cipher
+// connection, and the server end of it, which the test drives packet by
+// packet.
+func rawClient(t *testing.T) (*Client, *transport) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	a, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Client{transport: rawTransport(a), option: &ClientOption{}}
+	c.startLoop(func() {})
+	t.Cleanup(func() {
+		b.Close()
+		c.Close()
+	})
+	return c, rawTransport(b)
+}
+
+// Answer the next channel open with a window of window bytes, returning the
+// client's channel id.
+func confirmOpen(t *testing.T, server *transport, window uint32) uint32 {
+	p, err := server.readPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var code byte
+	var chanType string
+	var id uint32
+	NewDecoder(p).Byte(&code).U32String(&chanType).U32(&id)
+	if code != MsgChannelOpen {
+		t.Fatal("expected a channel open, got", code)
+	}
+	server.Packet().Byte(MsgChannelOpenConfirmation).U32(id).U32(100 + id).U32(window).U32(32 * 1024).Commit()
+	return id
+}
+
+func TestNewClientFailure(t *testing.T) {
+	_, k, _ := ed25519.GenerateKey(crand.Reader)
+	signer, _ := NewSigner(k)
+	for i := 0; i < 20; i++ {
+		cfg := &xssh.ServerConfig{PublicKeyCallback: func(xssh.ConnMetadata, xssh.PublicKey) (*xssh.Permissions, error) {
+			return nil, errors.New("unknown key")
+		}}
+		c, err := NewClient(testServer(t, cfg, nil), &ClientOption{User: "u", Signers: []Signer{signer}})
+		if (c != nil) || (err == nil) {
+			t.Fatal("authenticated with an unknown key")
+		}
+	}
+
+	// the server is gone before the handshake
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	a, b := net.Pipe()
+	b.Close()
+	if _, err := NewClientContext(ctx, a, testPassword("p")); err == nil {
+		t.Fatal("no error")
+	}
+}
+
+func TestNewClientHangup(t *testing.T) {
+	// the server hangs up at once, after its version, and after its kexinit
+	for _, script := range []func(net.Conn){
+		func(c net.Conn) {},
+		func(c net.Conn) {
+			c.Write([]byte("SSH-2.0-test\r\n"))
+		},
+		func(c net.Conn) {
+			c.Write([]byte("SSH-2.0-test\r\n"))
+			payload := NewEncoder().Byte(MsgKexinit).Bytes(make([]byte, 16)).
+				U32String(NameListKexAlgorithms).U32String(NameListServerHostKeyAlgorithms).
+				U32String(NameListEncryptionAlgorithms1).U32String(NameListEncryptionAlgorithms2).
+				U32String(NameListMacAlgorithms1).U32String(NameListMacAlgorithms2).
+				U32String(NameListCompressionAlgorithms1).U32String(NameListCompressionAlgorithms2).
+				U32String("").U32String("").Byte(0).U32(0).Out()
+			padding := 8 - (len(payload)+5)%8 + 8
+			c.Write(NewEncoder().U32(uint32(len(payload) + padding + 1)).Byte(byte(padding)).
+				Bytes(payload).Bytes(make([]byte, padding)).Out())
+			io.ReadFull(c, make([]byte, 100))
+		},
+	} {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			c, err := ln.Accept()
+			ln.Close()
+			if err == nil {
+				script(c)
+				c.Close()
+			}
+		}()
+
+		_, err = Dial("tcp", ln.Addr().String(), &ClientOption{Timeout: 5 * time.Second})
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatal(err)
+		}
+	}
+}
+
+// A packet for a channel that does not exist ends the connection, failing
+// whatever waits for the server.
+func TestClientBogusChannelId(t *testing.T) {
+	c, server := rawClient(t)
+
+	dialed := make(chan *TunnelConn)
+	go func() {
+		conn, _ := c.DialTCP("127.0.0.1", 9)
+		dialed <- conn
+	}()
+	confirmOpen(t, server, 1024)
+	conn := <-dialed
+	if conn == nil {
+		t.FailNow()
+	}
+
+	results := make(chan error, 3)
+	go func() {
+		_, err := c.DialTCP("127.0.0.1", 9)
+		results <- err
+	}()
+	go func() {
+		_, _, err := c.SendRequest("global", true, nil)
+		results <- err
+	}()
+	go func() {
+		_, err := conn.SendRequest("channel", true, nil)
+		results <- err
+	}()
+	read := make(chan error, 1)
+	go func() {
+		_, err := io.ReadAll(conn)
+		read <- err
+	}()
+	// the open and both requests reach the server unanswered
+	for i := 0; i < 3; i++ {
+		if _, err := server.readPacket(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	server.Packet().Byte(MsgChannelData).U32(999).U32String("x").Commit()
+	for i := 0; i < 3; i++ {
+		select {
+		case err := <-results:
+			if err == nil {
+				t.Fatal("request succeeded")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("still waiting after the connection failed")
+		}
+	}
+	select {
+	case err := <-read:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("channel still open after the connection failed")
+	}
+	if _, _, err := c.SendRequest("global", true, nil); !errors.Is(err, ErrClientClosed) {
+		t.Fatal(err)
+	}
+}
@@ -1,16 +1,14 @@
 package ssh
 
 import (
-    "crypto"
     "crypto/cipher"
     "crypto/hmac"
-    "crypto/rsa"
     "crypto/sha1"
     "errors"
     "math/big"
 )
 
-func (t *transport) dh(k *kexres, checkHostKey func([]byte)error) error {
+func (t *transport) dh(k *kexres, checkHostKey func(PublicKey) error) error {
     switch k.Kex {
     case "diffie-hellman-group1-sha1":
         return t.dhWith(k, checkHostKey, dh1_prime, dh1_gen)
@@ -20,61 +18,64 @@ func (t *transport) dh(k *kexres, checkHostKey func([]byte)error) error {
     return errors.New("Unknown kex method: " + k.Kex)
 }
 
-func (s *transport) dhWith(k *kexres, checkHostKey func([]byte) error, prime, gen *big.Int) error {
+func (s *transport) dhWith(k *kexres, checkHostKey func(PublicKey) error, prime, gen *big.Int) error {
     // C: MsgKexdhInit
     X, E := dhGenKey(gen, prime)
     s.Packet().Byte(MsgKexdhInit).U32Bytes(bS(E)).Commit()
 
     // S: MsgKexdhReply
-    packet := <-s.packets
+    packet, err := s.kexPacket()
+    if err != nil {
+        return err
+    }
     var code byte
     var H, K_S, Fs, signatureH []byte
     if !NewDecoder(packet).Byte(&code).U32Bytes(&K_S).U32Bytes(&Fs).U32Bytes(&signatureH).IsEnd() {
         return errors.New("packet has unparsed data")
     }
 
-    if checkHostKey != nil {
-        err := checkHostKey(K_S)
-        if err != nil {
-            return err
-        }
+    hostKey, err := ParsePublicKey(K_S)
+    if err != nil {
+        return err
+    }
+    if hostKey.Type() != k.Shk {
+        return errors.New("unexpected host key type: " + hostKey.Type())
     }
 
     F := pS(Fs)
     K := big.NewInt(0).Exp(F, X, prime)
 
-    var skAlgo string
-    skP := NewDecoder(K_S)
-    skP.U32String(&skAlgo)
-
-    firstTime := s.session_id == nil
-
-    switch skAlgo {
-    case "ssh-rsa":
-        var rsaes, rsans []byte
-        skP.U32Bytes(&rsaes).U32Bytes(&rsans)
-        skPub := &rsa.PublicKey{pS(rsans), int(pS(rsaes).Int64())}
-        H = hashSHA1(NewEncoder().U32String(ident).U32String(s.rident).U32Bytes(s.ckex).U32Bytes(s.skex).U32Bytes(K_S).U32Bytes(bS(E)).U32Bytes(Fs).U32Bytes(bS(K)).Out())
-        if firstTime {
-            s.session_id = H
-        }
-        var sigalgo string
-        var sigdata []byte
-        NewDecoder(signatureH).U32String(&sigalgo).U32Bytes(&sigdata).End()
+    H = hashSHA1(NewEncoder().U32String(ident).U32String(s.rident).U32Bytes(s.ckex).U32Bytes(s.skex).U32Bytes(K_S).U32Bytes(bS(E)).U32Bytes(Fs).U32Bytes(bS(K)).Out())
+    // the exchange hash is signed with the negotiated host key algorithm
+    sigalgo, _, err := parseSignature(signatureH)
+    if err != nil {
+        return err
+    }
+    if sigalgo != k.Shk {
+        return errors.New("unexpected host key signature: " + sigalgo)
+    }
+    if err = hostKey.Verify(H, signatureH); err != nil {
+        return err
+    }
+    if s.session_id == nil {
+        s.session_id = H
+    }
 
-        err := rsa.VerifyPKCS1v15(skPub, crypto.SHA1, hashSHA1(H), sigdata)
-        if err != nil {
+    // the server proved it holds the key, let the caller decide to trust it
+    if checkHostKey != nil {
+        if err = checkHostKey(hostKey); err != nil {
             return err
         }
-    default:
-        panic(skAlgo)
     }
 
     // C: MsgNewkeys
     s.Packet().Byte(MsgNewkeys).Commit()
 
     // S: MsgNewkeys
-    packet = <-s.packets
+    packet, err = s.kexPacket()
+    if err != nil {
+        return err
+    }
     if len(packet) != 1 {
         return errors.New("packet has unparsed data")
     } else if code = packet[0]; code != MsgNewkeys {
@@ -0,0 +1,117 @@
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+// A bastion to connect through, see ClientOption.JumpHosts.
+type JumpHost struct {
+	// Address of the bastion, "host:port".
+	Addr string
+
+	// Options to connect to the bastion; Host defaults to Addr and its
+	// JumpHosts are ignored.
+	Option *ClientOption
+}
+
+// Connecting through jump hosts failed at a hop, counted from 1; the hop
+// after the last jump host is the target.
+type JumpError struct {
+	Hop  int
+	Addr string
+	Err  error
+}
+
+func (e *JumpError) Error() string {
+	return fmt.Sprintf("hop %v (%v): %v", e.Hop, e.Addr, e.Err)
+}
+
+func (e *JumpError) Unwrap() error {
+	return e.Err
+}
+
+// Connect to addr, e.g. "tcp" and "host:22", and set up a client over the
+// connection, see DialContext.
+func Dial(network, addr string, option *ClientOption) (*Client, error) {
+	return DialContext(context.Background(), network, addr, option)
+}
+
+// Connect to addr, then exchange keys and authenticate as NewClient does,
+// giving up when ctx is done or option.Timeout passed. Host keys are checked
+// for addr unless option.Host is set.
+//
+// With option.JumpHosts, each hop is connected to over a tunnel of the
+// client of the previous one and a failure is a *JumpError. Closing the
+// returned client closes the whole chain.
+func DialContext(ctx context.Context, network, addr string, option *ClientOption) (*Client, error) {
+	o := ClientOption{}
+	if option != nil {
+		o = *option
+	}
+	if o.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.Timeout)
+		defer cancel()
+	}
+
+	var via *Client
+	for i, hop := range o.JumpHosts {
+		client, err := dialHop(ctx, via, "tcp", hop.Addr, hop.Option)
+		if err != nil {
+			if via != nil {
+				via.Close()
+			}
+			return nil, &JumpError{i + 1, hop.Addr, err}
+		}
+		via = client
+	}
+
+	client, err := dialHop(ctx, via, network, addr, &o)
+	if err != nil {
+		if via == nil {
+			return nil, err
+		}
+		via.Close()
+		return nil, &JumpError{len(o.JumpHosts) + 1, addr, err}
+	}
+	return client, nil
+}
+
+// Connect to addr directly or, with via, over a tunnel of that client, which
+// the new client then owns.
+func dialHop(ctx context.Context, via *Client, network, addr string, option *ClientOption) (*Client, error) {
+	o := ClientOption{}
+	if option != nil {
+		o = *option
+	}
+	o.JumpHosts = nil
+	if len(o.Host) == 0 {
+		o.Host = addr
+	}
+	if o.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.Timeout)
+		defer cancel()
+	}
+
+	var conn net.Conn
+	var err error
+	if via == nil {
+		var d net.Dialer
+		conn, err = d.DialContext(ctx, network, addr)
+	} else {
+		conn, err = via.DialContext(ctx, network, addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	client, err := NewClientContext(ctx, conn, &o)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	client.jump = via
+	return client, nil
+}
@@ -0,0 +1,103 @@
+package ssh
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	xssh "golang.org/x/crypto/ssh"
+)
+
+func defaultServerConfig() *xssh.ServerConfig {
+	return nil
+}
+
+// Close c, failing if it does not return in time.
+func closeTest(t *testing.T, c *Client) {
+	done := make(chan int)
+	go func() {
+		c.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not return")
+	}
+}
+
+func TestDialJumpHosts(t *testing.T) {
+	target := testListener(t, defaultServerConfig, nil)
+	defer target.Close()
+	bastion1 := testListener(t, defaultServerConfig, directTCPServer)
+	defer bastion1.Close()
+	bastion2 := testListener(t, defaultServerConfig, directTCPServer)
+	defer bastion2.Close()
+
+	o := testPassword("p")
+	o.JumpHosts = []JumpHost{{bastion1.Addr().String(), testPassword("p")}, {bastion2.Addr().String(), testPassword("p")}}
+	c, err := Dial("tcp", target.Addr().String(), o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (c.jump == nil) || (c.jump.jump == nil) || (c.jump.jump.jump != nil) {
+		t.Fatal("chain not recorded")
+	}
+	jumps := []*Client{c.jump, c.jump.jump}
+	closeTest(t, c)
+	closeTest(t, c)
+	for _, j := range jumps {
+		select {
+		case <-j.done:
+		default:
+			t.Fatal("jump host left open")
+		}
+	}
+
+	// failures name the hop
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	closed.Close()
+	var je *JumpError
+	if _, err := Dial("tcp", closed.Addr().String(), o); !errors.As(err, &je) || (je.Hop != 3) {
+		t.Fatal(err)
+	}
+	o.JumpHosts[1].Addr = closed.Addr().String()
+	if _, err := Dial("tcp", target.Addr().String(), o); !errors.As(err, &je) || (je.Hop != 2) {
+		t.Fatal(err)
+	}
+}
+
+func TestDialJumpHostDrop(t *testing.T) {
+	target := testListener(t, defaultServerConfig, nil)
+	defer target.Close()
+	conns := make(chan *xssh.ServerConn, 1)
+	bastion := testListener(t, defaultServerConfig, func(sc *xssh.ServerConn, chans <-chan xssh.NewChannel, reqs <-chan *xssh.Request) {
+		conns <- sc
+		directTCPServer(sc, chans, reqs)
+	})
+	defer bastion.Close()
+
+	o := testPassword("p")
+	o.JumpHosts = []JumpHost{{bastion.Addr().String(), testPassword("p")}}
+	c, err := Dial("tcp", target.Addr().String(), o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jump := c.jump
+
+	// the bastion hangs up, taking the tunnel to the target with it
+	(<-conns).Close()
+	select {
+	case <-c.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the target connection did not end")
+	}
+	closeTest(t, c)
+	closeTest(t, c)
+	select {
+	case <-jump.done:
+	default:
+		t.Fatal("jump host left open")
+	}
+}
@@ -0,0 +1,223 @@
+package ssh
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// Local port forwarding (ssh -L): connections accepted on a local listener
+// are piped through direct-tcpip channels to a host and port reached from
+// the server.
+type Forward struct {
+	client   *Client
+	listener net.Listener
+
+	// Choose the destination of an accepted connection; reply, if not nil,
+	// reports the result of the channel open to the local peer.
+	target func(conn net.Conn) (host string, port int, reply func(err error) error, err error)
+
+	// Cancelled by Close, abandoning channel opens the server did not
+	// answer yet.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mutex   sync.Mutex
+	conns   map[io.Closer]bool
+	closing bool
+	err     error
+
+	accepted, active, failed int64
+	sent, received           int64
+
+	wait sync.WaitGroup
+}
+
+// Counters of a Forward.
+type ForwardStats struct {
+	// Accepted connections, those still open and those whose channel
+	// could not be opened.
+	Accepted, Active, Failed int64
+
+	// Bytes sent to and received from the server side.
+	Sent, Received int64
+
+	// Last error of the forward, e.g. an OpenChannelError or the error that
+	// stopped the listener.
+	Err error
+}
+
+// Forward connections accepted on listener to host:port as seen from the
+// server, until Close. Each channel carries the peer of the accepted
+// connection as originator.
+func (c *Client) Forward(listener net.Listener, host string, port int) *Forward {
+	return c.forward(listener, func(conn net.Conn) (string, int, func(error) error, error) {
+		return host, port, nil, nil
+	})
+}
+
+func (c *Client) forward(listener net.Listener, target func(conn net.Conn) (string, int, func(error) error, error)) *Forward {
+	f := &Forward{client: c, listener: listener, target: target, conns: map[io.Closer]bool{}}
+	f.ctx, f.cancel = context.WithCancel(context.Background())
+	f.wait.Add(1)
+	go f.accept()
+	return f
+}
+
+func (f *Forward) accept() {
+	defer f.wait.Done()
+
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			f.mutex.Lock()
+			if !f.closing {
+				f.err = err
+			}
+			f.mutex.Unlock()
+			return
+		}
+
+		atomic.AddInt64(&f.accepted, 1)
+		if !f.track(conn) {
+			conn.Close()
+			return
+		}
+		f.wait.Add(1)
+		go f.pipe(conn)
+	}
+}
+
+// Register c to be closed by Close; false once closing.
+func (f *Forward) track(c io.Closer) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.closing {
+		return false
+	}
+	f.conns[c] = true
+	return true
+}
+
+func (f *Forward) untrack(c io.Closer) {
+	f.mutex.Lock()
+	delete(f.conns, c)
+	f.mutex.Unlock()
+}
+
+func (f *Forward) fail(err error) {
+	atomic.AddInt64(&f.failed, 1)
+	f.mutex.Lock()
+	f.err = err
+	f.mutex.Unlock()
+	Log(5, "forward from %v: %v", f.listener.Addr(), err)
+}
+
+// Copy both ways until both sides sent EOF or the server closed the channel,
+// passing half-closes on.
+func (f *Forward) pipe(conn net.Conn) {
+	defer f.wait.Done()
+	defer f.untrack(conn)
+	defer conn.Close()
+
+	host, port, reply, err := f.target(conn)
+	if err != nil {
+		f.fail(err)
+		return
+	}
+
+	originHost, originPort := splitOriginator(conn.RemoteAddr())
+	tunnel, err := f.client.dialTCP(f.ctx, host, port, originHost, originPort)
+	if reply != nil {
+		if e := reply(err); (e != nil) && (err == nil) {
+			err = e
+			tunnel.Close()
+		}
+	}
+	if err != nil {
+		// opens abandoned by Close are no failure
+		if f.ctx.Err() == nil {
+			f.fail(err)
+		}
+		return
+	}
+	if !f.track(tunnel) {
+		tunnel.Close()
+		return
+	}
+	defer f.untrack(tunnel)
+	defer tunnel.Close()
+
+	atomic.AddInt64(&f.active, 1)
+	defer atomic.AddInt64(&f.active, -1)
+
+	done := make(chan int, 1)
+	go func() {
+		io.Copy(countingWriter{tunnel, &f.sent}, conn)
+		tunnel.CloseWrite()
+		done <- 0
+	}()
+
+	io.Copy(countingWriter{conn, &f.received}, tunnel)
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	} else {
+		conn.Close()
+	}
+
+	// after EOF the server may still read, after the channel closed it won't
+	select {
+	case <-done:
+	case <-tunnel.channelClosed:
+		conn.Close()
+		<-done
+	}
+}
+
+// Adds the bytes written to *n.
+type countingWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (w countingWriter) Write(data []byte) (n int, err error) {
+	n, err = w.w.Write(data)
+	atomic.AddInt64(w.n, int64(n))
+	return
+}
+
+// Current counters.
+func (f *Forward) Stats() ForwardStats {
+	f.mutex.Lock()
+	err := f.err
+	f.mutex.Unlock()
+
+	return ForwardStats{
+		Accepted: atomic.LoadInt64(&f.accepted),
+		Active:   atomic.LoadInt64(&f.active),
+		Failed:   atomic.LoadInt64(&f.failed),
+		Sent:     atomic.LoadInt64(&f.sent),
+		Received: atomic.LoadInt64(&f.received),
+		Err:      err,
+	}
+}
+
+// Close the listener and all forwarded connections, and wait until their
+// goroutines ended.
+func (f *Forward) Close() error {
+	f.cancel()
+	f.mutex.Lock()
+	f.closing = true
+	conns := f.conns
+	f.conns = map[io.Closer]bool{}
+	f.mutex.Unlock()
+
+	err := f.listener.Close()
+	for c := range conns {
+		c.Close()
+	}
+	f.wait.Wait()
+	return err
+}
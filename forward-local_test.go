@@ -0,0 +1,170 @@
+package ssh
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	xssh "golang.org/x/crypto/ssh"
+)
+
+// Listen on loopback and serve each connection with handle.
+func tcpTarget(t *testing.T, handle func(conn net.Conn)) *net.TCPAddr {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go handle(conn)
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr)
+}
+
+func echo(conn net.Conn) {
+	io.Copy(conn, conn)
+	conn.Close()
+}
+
+func localListener(t *testing.T) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ln
+}
+
+// Wait until cond holds.
+func eventually(t *testing.T, what string, cond func() bool) {
+	for start := time.Now(); !cond(); time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal(what)
+		}
+	}
+}
+
+func TestForward(t *testing.T) {
+	c := dialTest(t, directTCPServer)
+	defer c.Close()
+	target := tcpTarget(t, echo)
+
+	ln := localListener(t)
+	f := c.Forward(ln, "127.0.0.1", target.Port)
+	defer f.Close()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("hello"))
+	conn.(*net.TCPConn).CloseWrite()
+	if b, err := io.ReadAll(conn); (string(b) != "hello") || (err != nil) {
+		t.Fatal(string(b), err)
+	}
+	conn.Close()
+
+	eventually(t, "connection still active", func() bool { return f.Stats().Active == 0 })
+	s := f.Stats()
+	if (s.Accepted != 1) || (s.Failed != 0) || (s.Sent != 5) || (s.Received != 5) || (s.Err != nil) {
+		t.Fatalf("%+v", s)
+	}
+
+	// a refused open is counted and reported
+	closed := localListener(t)
+	closed.Close()
+	f2 := c.Forward(localListener(t), "127.0.0.1", closed.Addr().(*net.TCPAddr).Port)
+	defer f2.Close()
+	conn, err = net.Dial("tcp", f2.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatal(err)
+	}
+	var oe *OpenChannelError
+	if s := f2.Stats(); (s.Failed != 1) || !errors.As(s.Err, &oe) {
+		t.Fatalf("%+v", s)
+	}
+}
+
+// When the target closes, the local connection is closed even if its peer
+// stays idle.
+func TestForwardServerClose(t *testing.T) {
+	c := dialTest(t, directTCPServer)
+	defer c.Close()
+	target := tcpTarget(t, func(conn net.Conn) {
+		conn.Write([]byte("bye"))
+		conn.Close()
+	})
+
+	ln := localListener(t)
+	f := c.Forward(ln, "127.0.0.1", target.Port)
+	defer f.Close()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if b, err := io.ReadAll(conn); (string(b) != "bye") || (err != nil) {
+		t.Fatal(string(b), err)
+	}
+	eventually(t, "connection still active", func() bool { return f.Stats().Active == 0 })
+	f.mutex.Lock()
+	n := len(f.conns)
+	f.mutex.Unlock()
+	if n != 0 {
+		t.Fatal(n, "connections tracked")
+	}
+}
+
+// Close does not wait for channel opens the server never answers.
+func TestForwardCloseUnanswered(t *testing.T) {
+	opens := make(chan xssh.NewChannel, 4)
+	c := dialTest(t, func(sc *xssh.ServerConn, chans <-chan xssh.NewChannel, reqs <-chan *xssh.Request) {
+		go xssh.DiscardRequests(reqs)
+		for nc := range chans {
+			opens <- nc
+		}
+	})
+	defer c.Close()
+
+	ln := localListener(t)
+	f := c.Forward(ln, "127.0.0.1", 1)
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	<-opens
+
+	closed := make(chan error)
+	go func() { closed <- f.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close waits for the channel open")
+	}
+	if s := f.Stats(); (s.Failed != 0) || (s.Err != nil) {
+		t.Fatalf("%+v", s)
+	}
+	if _, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+		t.Fatal("listener still open")
+	}
+
+	// the client is still usable
+	if _, _, err := c.SendRequest("x", true, nil); err != nil {
+		t.Fatal(err)
+	}
+}
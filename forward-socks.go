@@ -0,0 +1,238 @@
+package ssh
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SOCKS5 reply codes (RFC1928/6).
+const (
+	socks5Succeeded               = 0
+	socks5GeneralFailure          = 1
+	socks5NotAllowed              = 2
+	socks5NetworkUnreachable      = 3
+	socks5HostUnreachable         = 4
+	socks5ConnectionRefused       = 5
+	socks5CommandNotSupported     = 7
+	socks5AddressTypeNotSupported = 8
+)
+
+// Time a SOCKS client has to send its request.
+const socksHandshakeTimeout = 30 * time.Second
+
+// Dynamic port forwarding (ssh -D): serve SOCKS4, SOCKS4a and SOCKS5 CONNECT
+// requests on listener, each through a direct-tcpip channel. With auth
+// set, SOCKS5 clients must log in with username/password (RFC1929) and
+// SOCKS4 clients, which cannot, are refused.
+func (c *Client) ForwardSOCKS(listener net.Listener, auth func(user, password string) bool) *Forward {
+	return c.forward(listener, func(conn net.Conn) (string, int, func(error) error, error) {
+		conn.SetDeadline(time.Now().Add(socksHandshakeTimeout))
+		host, port, reply, err := socksHandshake(conn, auth)
+		if err != nil {
+			return "", 0, nil, err
+		}
+		return host, port, func(err error) error {
+			defer conn.SetDeadline(time.Time{})
+			return reply(err)
+		}, nil
+	})
+}
+
+func socksHandshake(conn net.Conn, auth func(user, password string) bool) (host string, port int, reply func(err error) error, err error) {
+	version := make([]byte, 1)
+	if _, err = io.ReadFull(conn, version); err != nil {
+		return
+	}
+	switch version[0] {
+	case 4:
+		return socks4Request(conn, auth)
+	case 5:
+		return socks5Request(conn, auth)
+	}
+	return "", 0, nil, errors.New("socks: unsupported version " + strconv.Itoa(int(version[0])))
+}
+
+// SOCKS4 and SOCKS4a CONNECT, after the version byte.
+func socks4Request(conn net.Conn, auth func(user, password string) bool) (host string, port int, reply func(err error) error, err error) {
+	b := make([]byte, 7)
+	if _, err = io.ReadFull(conn, b); err != nil {
+		return
+	}
+	user, err := readCString(conn)
+	if err != nil {
+		return
+	}
+
+	refuse := func(err error) (string, int, func(error) error, error) {
+		conn.Write([]byte{0, 91, 0, 0, 0, 0, 0, 0})
+		return "", 0, nil, err
+	}
+	if b[0] != 1 {
+		return refuse(errors.New("socks4: unsupported command " + strconv.Itoa(int(b[0]))))
+	}
+	if auth != nil {
+		return refuse(errors.New("socks4: login required, refusing user " + user))
+	}
+
+	port = int(b[1])<<8 | int(b[2])
+	host = net.IP(b[3:7]).String()
+	if (b[3] == 0) && (b[4] == 0) && (b[5] == 0) && (b[6] != 0) {
+		// SOCKS4a: 0.0.0.x is followed by the host name
+		if host, err = readCString(conn); err != nil {
+			return
+		}
+	}
+
+	reply = func(err error) error {
+		code := byte(90)
+		if err != nil {
+			code = 91
+		}
+		_, e := conn.Write([]byte{0, code, 0, 0, 0, 0, 0, 0})
+		return e
+	}
+	return
+}
+
+func readCString(r io.Reader) (string, error) {
+	var s []byte
+	b := make([]byte, 1)
+	for len(s) < 256 {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return "", err
+		}
+		if b[0] == 0 {
+			return string(s), nil
+		}
+		s = append(s, b[0])
+	}
+	return "", errors.New("socks4: string too long")
+}
+
+// SOCKS5 method negotiation and CONNECT, after the version byte.
+func socks5Request(conn net.Conn, auth func(user, password string) bool) (host string, port int, reply func(err error) error, err error) {
+	n := make([]byte, 1)
+	if _, err = io.ReadFull(conn, n); err != nil {
+		return
+	}
+	methods := make([]byte, n[0])
+	if _, err = io.ReadFull(conn, methods); err != nil {
+		return
+	}
+
+	method := byte(0) // no authentication required
+	if auth != nil {
+		method = 2 // username/password
+	}
+	if !strings.Contains(string(methods), string([]byte{method})) {
+		conn.Write([]byte{5, 0xff})
+		return "", 0, nil, errors.New("socks5: no acceptable authentication method")
+	}
+	if _, err = conn.Write([]byte{5, method}); err != nil {
+		return
+	}
+	if auth != nil {
+		if err = socks5Login(conn, auth); err != nil {
+			return
+		}
+	}
+
+	b := make([]byte, 4)
+	if _, err = io.ReadFull(conn, b); err != nil {
+		return
+	}
+	switch b[3] {
+	case 1, 4:
+		ip := make([]byte, net.IPv4len)
+		if b[3] == 4 {
+			ip = make([]byte, net.IPv6len)
+		}
+		if _, err = io.ReadFull(conn, ip); err != nil {
+			return
+		}
+		host = net.IP(ip).String()
+	case 3:
+		if _, err = io.ReadFull(conn, n); err != nil {
+			return
+		}
+		name := make([]byte, n[0])
+		if _, err = io.ReadFull(conn, name); err != nil {
+			return
+		}
+		host = string(name)
+	default:
+		socks5Reply(conn, socks5AddressTypeNotSupported)
+		return "", 0, nil, errors.New("socks5: unsupported address type " + strconv.Itoa(int(b[3])))
+	}
+
+	p := make([]byte, 2)
+	if _, err = io.ReadFull(conn, p); err != nil {
+		return
+	}
+	port = int(p[0])<<8 | int(p[1])
+
+	if b[1] != 1 {
+		socks5Reply(conn, socks5CommandNotSupported)
+		return "", 0, nil, errors.New("socks5: unsupported command " + strconv.Itoa(int(b[1])))
+	}
+
+	reply = func(err error) error {
+		return socks5Reply(conn, socks5ReplyCode(err))
+	}
+	return
+}
+
+// Username/password sub-negotiation (RFC1929).
+func socks5Login(conn net.Conn, auth func(user, password string) bool) error {
+	n := make([]byte, 2)
+	if _, err := io.ReadFull(conn, n); err != nil {
+		return err
+	}
+	user := make([]byte, n[1])
+	if _, err := io.ReadFull(conn, user); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(conn, n[:1]); err != nil {
+		return err
+	}
+	password := make([]byte, n[0])
+	if _, err := io.ReadFull(conn, password); err != nil {
+		return err
+	}
+
+	if !auth(string(user), string(password)) {
+		conn.Write([]byte{1, 1})
+		return errors.New("socks5: login failed for user " + string(user))
+	}
+	_, err := conn.Write([]byte{1, 0})
+	return err
+}
+
+func socks5Reply(conn net.Conn, code byte) error {
+	_, err := conn.Write([]byte{5, code, 0, 1, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// Map the result of a channel open to a SOCKS5 reply code, by the reason
+// code of the server's refusal.
+func socks5ReplyCode(err error) byte {
+	if err == nil {
+		return socks5Succeeded
+	}
+
+	var e *OpenChannelError
+	if !errors.As(err, &e) {
+		return socks5GeneralFailure
+	}
+	switch e.Reason {
+	case OpenAdministrativelyProhibited:
+		return socks5NotAllowed
+	case OpenConnectFailed:
+		return socks5HostUnreachable
+	}
+	return socks5GeneralFailure
+}
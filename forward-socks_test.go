@@ -0,0 +1,180 @@
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+func cat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func TestSocksHandshake(t *testing.T) {
+	login := func(user, password string) bool {
+		return (user == "user") && (password == "pw")
+	}
+	refused := &OpenChannelError{Reason: OpenConnectFailed, Message: "connect failed"}
+	socks4OK := []byte{0, 90, 0, 0, 0, 0, 0, 0}
+	socks4Refused := []byte{0, 91, 0, 0, 0, 0, 0, 0}
+	socks5OK := []byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0}
+
+	tests := []struct {
+		name    string
+		auth    func(user, password string) bool
+		request []byte
+		openErr error
+
+		host string
+		port int
+		fail bool
+		// all the server wrote
+		replies []byte
+	}{
+		{
+			name:    "socks4",
+			request: cat([]byte{4, 1, 0, 80, 1, 2, 3, 4}, []byte("user\x00")),
+			host:    "1.2.3.4", port: 80,
+			replies: socks4OK,
+		},
+		{
+			name:    "socks4 refused open",
+			request: []byte{4, 1, 0, 80, 1, 2, 3, 4, 0},
+			openErr: refused,
+			host:    "1.2.3.4", port: 80,
+			replies: socks4Refused,
+		},
+		{
+			name:    "socks4a",
+			request: cat([]byte{4, 1, 1, 187, 0, 0, 0, 1, 0}, []byte("example.com\x00")),
+			host:    "example.com", port: 443,
+			replies: socks4OK,
+		},
+		{
+			name:    "socks4 bind",
+			request: []byte{4, 2, 0, 80, 1, 2, 3, 4, 0},
+			fail:    true,
+			replies: socks4Refused,
+		},
+		{
+			name:    "socks4 with login required",
+			auth:    login,
+			request: []byte{4, 1, 0, 80, 1, 2, 3, 4, 0},
+			fail:    true,
+			replies: socks4Refused,
+		},
+		{
+			name:    "socks5 ipv4",
+			request: []byte{5, 1, 0, 5, 1, 0, 1, 1, 2, 3, 4, 0, 80},
+			host:    "1.2.3.4", port: 80,
+			replies: cat([]byte{5, 0}, socks5OK),
+		},
+		{
+			name:    "socks5 domain",
+			request: cat([]byte{5, 2, 2, 0, 5, 1, 0, 3, 11}, []byte("example.com"), []byte{1, 187}),
+			host:    "example.com", port: 443,
+			replies: cat([]byte{5, 0}, socks5OK),
+		},
+		{
+			name:    "socks5 ipv6",
+			request: cat([]byte{5, 1, 0, 5, 1, 0, 4}, net.IPv6loopback, []byte{0, 22}),
+			host:    "::1", port: 22,
+			replies: cat([]byte{5, 0}, socks5OK),
+		},
+		{
+			name:    "socks5 refused open",
+			request: []byte{5, 1, 0, 5, 1, 0, 1, 1, 2, 3, 4, 0, 80},
+			openErr: refused,
+			host:    "1.2.3.4", port: 80,
+			replies: []byte{5, 0, 5, socks5HostUnreachable, 0, 1, 0, 0, 0, 0, 0, 0},
+		},
+		{
+			name:    "socks5 login",
+			auth:    login,
+			request: cat([]byte{5, 1, 2, 1, 4}, []byte("user"), []byte{2}, []byte("pw"), []byte{5, 1, 0, 1, 1, 2, 3, 4, 0, 80}),
+			host:    "1.2.3.4", port: 80,
+			replies: cat([]byte{5, 2, 1, 0}, socks5OK),
+		},
+		{
+			name:    "socks5 wrong password",
+			auth:    login,
+			request: cat([]byte{5, 1, 2, 1, 4}, []byte("user"), []byte{2}, []byte("no")),
+			fail:    true,
+			replies: []byte{5, 2, 1, 1},
+		},
+		{
+			name:    "socks5 no acceptable method",
+			auth:    login,
+			request: []byte{5, 1, 0},
+			fail:    true,
+			replies: []byte{5, 0xff},
+		},
+		{
+			name:    "socks5 bind",
+			request: []byte{5, 1, 0, 5, 2, 0, 1, 1, 2, 3, 4, 0, 80},
+			fail:    true,
+			replies: []byte{5, 0, 5, socks5CommandNotSupported, 0, 1, 0, 0, 0, 0, 0, 0},
+		},
+		{
+			name:    "socks5 unknown address type",
+			request: []byte{5, 1, 0, 5, 1, 0, 9},
+			fail:    true,
+			replies: []byte{5, 0, 5, socks5AddressTypeNotSupported, 0, 1, 0, 0, 0, 0, 0, 0},
+		},
+		{
+			name:    "unsupported version",
+			request: []byte{6},
+			fail:    true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			go client.Write(test.request)
+			written := make(chan []byte)
+			go func() {
+				b, _ := io.ReadAll(client)
+				written <- b
+			}()
+
+			host, port, reply, err := socksHandshake(server, test.auth)
+			if (err != nil) != test.fail {
+				t.Fatal(err)
+			}
+			if (host != test.host) || (port != test.port) {
+				t.Fatal(host, port)
+			}
+			if reply != nil {
+				if err := reply(test.openErr); err != nil {
+					t.Fatal(err)
+				}
+			}
+			server.Close()
+			if b := <-written; !bytes.Equal(b, test.replies) {
+				t.Fatalf("replies %v, want %v", b, test.replies)
+			}
+		})
+	}
+}
+
+func TestSocks5ReplyCode(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		code byte
+	}{
+		{nil, socks5Succeeded},
+		{&OpenChannelError{Reason: OpenAdministrativelyProhibited}, socks5NotAllowed},
+		{&OpenChannelError{Reason: OpenConnectFailed, Message: "Connection refused"}, socks5HostUnreachable},
+		{&OpenChannelError{Reason: OpenUnknownChannelType}, socks5GeneralFailure},
+		{&OpenChannelError{Reason: OpenResourceShortage}, socks5GeneralFailure},
+		{errors.New("open channel"), socks5GeneralFailure},
+	} {
+		if code := socks5ReplyCode(test.err); code != test.code {
+			t.Error(test.err, code)
+		}
+	}
+}
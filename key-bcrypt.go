@@ -0,0 +1,81 @@
+package ssh
+
+import (
+	"crypto/sha512"
+	"errors"
+
+	"golang.org/x/crypto/blowfish"
+)
+
+// Derive a key with bcrypt_pbkdf as used by OpenSSH to encrypt private keys.
+func bcryptPBKDF(password, salt []byte, rounds, keyLen int) ([]byte, error) {
+	if rounds < 1 {
+		return nil, errors.New("bcrypt_pbkdf: too few rounds")
+	}
+	if len(password) == 0 {
+		return nil, errors.New("bcrypt_pbkdf: empty passphrase")
+	}
+	if (len(salt) == 0) || (len(salt) > 1<<20) {
+		return nil, errors.New("bcrypt_pbkdf: bad salt length")
+	}
+	if keyLen > 1024 {
+		return nil, errors.New("bcrypt_pbkdf: key too long")
+	}
+
+	const blockSize = 32
+	blocks := (keyLen + blockSize - 1) / blockSize
+	key := make([]byte, blocks*blockSize)
+
+	h := sha512.New()
+	h.Write(password)
+	shapass := h.Sum(nil)
+
+	shasalt := make([]byte, 0, sha512.Size)
+	tmp := make([]byte, blockSize)
+	out := make([]byte, blockSize)
+	for block := 1; block <= blocks; block++ {
+		h.Reset()
+		h.Write(salt)
+		h.Write([]byte{byte(block >> 24), byte(block >> 16), byte(block >> 8), byte(block)})
+		bcryptHash(tmp, shapass, h.Sum(shasalt))
+		copy(out, tmp)
+
+		for i := 2; i <= rounds; i++ {
+			h.Reset()
+			h.Write(tmp)
+			bcryptHash(tmp, shapass, h.Sum(shasalt))
+			for j := range out {
+				out[j] ^= tmp[j]
+			}
+		}
+
+		// output bytes are spread over the blocks
+		for i, v := range out {
+			key[i*blocks+block-1] = v
+		}
+	}
+	return key[:keyLen], nil
+}
+
+func bcryptHash(out, shapass, shasalt []byte) {
+	c, err := blowfish.NewSaltedCipher(shapass, shasalt)
+	if err != nil {
+		panic(err)
+	}
+	for i := 0; i < 64; i++ {
+		blowfish.ExpandKey(shasalt, c)
+		blowfish.ExpandKey(shapass, c)
+	}
+
+	copy(out, "OxychromaticBlowfishSwatDynamite")
+	for i := 0; i < 32; i += 8 {
+		for j := 0; j < 64; j++ {
+			c.Encrypt(out[i:i+8], out[i:i+8])
+		}
+	}
+
+	// blowfish words are big endian, bcrypt_pbkdf wants little endian
+	for i := 0; i < 32; i += 4 {
+		out[i], out[i+1], out[i+2], out[i+3] = out[i+3], out[i+2], out[i+1], out[i]
+	}
+}
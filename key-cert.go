@@ -0,0 +1,224 @@
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Certificate types.
+const (
+	UserCert = 1
+	HostCert = 2
+)
+
+const certSuffix = "-cert-v01@openssh.com"
+
+// Valid forever, for Certificate.ValidBefore.
+const CertTimeInfinity = 1<<64 - 1
+
+// OpenSSH certificate (PROTOCOL.certkeys in the OpenSSH sources). A
+// certificate is also a PublicKey; together with its private key it can be
+// used for authentication, see NewCertSigner.
+type Certificate struct {
+	Nonce           []byte
+	Key             PublicKey
+	Serial          uint64
+	CertType        uint32
+	KeyId           string
+	ValidPrincipals []string
+	ValidAfter      uint64
+	ValidBefore     uint64
+	CriticalOptions map[string]string
+	Extensions      map[string]string
+	Reserved        []byte
+	SignatureKey    PublicKey
+	Signature       []byte
+}
+
+// Parse a certificate blob in SSH wire format.
+func ParseCertificate(blob []byte) (cert *Certificate, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			cert, err = nil, errors.New("malformed certificate")
+		}
+	}()
+
+	var certType string
+	c := &Certificate{}
+	p := NewDecoder(blob).U32String(&certType).U32Bytes(&c.Nonce)
+	if !strings.HasSuffix(certType, certSuffix) {
+		return nil, errors.New("not a certificate: " + certType)
+	}
+
+	// the certified key's fields follow the nonce; rebuild the plain key blob
+	keyType := strings.TrimSuffix(certType, certSuffix)
+	start := p.offset
+	var skip []byte
+	switch keyType {
+	case "ssh-rsa", "ecdsa-sha2-nistp256", "ecdsa-sha2-nistp384", "ecdsa-sha2-nistp521":
+		p.U32Bytes(&skip).U32Bytes(&skip)
+	case "ssh-ed25519":
+		p.U32Bytes(&skip)
+	default:
+		return nil, errors.New("unsupported certificate type: " + certType)
+	}
+	if c.Key, err = ParsePublicKey(NewEncoder().U32String(keyType).Bytes(blob[start:p.offset]).Out()); err != nil {
+		return nil, err
+	}
+
+	var principals, critical, extensions, signatureKey []byte
+	p.U64(&c.Serial).U32(&c.CertType).U32String(&c.KeyId).U32Bytes(&principals).
+		U64(&c.ValidAfter).U64(&c.ValidBefore).U32Bytes(&critical).U32Bytes(&extensions).
+		U32Bytes(&c.Reserved).U32Bytes(&signatureKey).U32Bytes(&c.Signature).End()
+
+	for q := NewDecoder(principals); !q.IsEnd(); {
+		var principal string
+		q.U32String(&principal)
+		c.ValidPrincipals = append(c.ValidPrincipals, principal)
+	}
+	c.CriticalOptions = parseCertOptions(critical)
+	c.Extensions = parseCertOptions(extensions)
+
+	if c.SignatureKey, err = ParsePublicKey(signatureKey); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Options are name/data pairs; non-empty data is itself a string.
+func parseCertOptions(b []byte) map[string]string {
+	m := map[string]string{}
+	for p := NewDecoder(b); !p.IsEnd(); {
+		var name string
+		var data []byte
+		p.U32String(&name).U32Bytes(&data)
+
+		var value string
+		if len(data) > 0 {
+			NewDecoder(data).U32String(&value).End()
+		}
+		m[name] = value
+	}
+	return m
+}
+
+func marshalCertOptions(m map[string]string) []byte {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	p := NewEncoder()
+	for _, name := range names {
+		p.U32String(name)
+		if value := m[name]; len(value) > 0 {
+			p.U32Bytes(NewEncoder().U32String(value).Out())
+		} else {
+			p.U32(0)
+		}
+	}
+	return p.Out()
+}
+
+func (c *Certificate) Type() string {
+	return c.Key.Type() + certSuffix
+}
+
+func (c *Certificate) Marshal() []byte {
+	return c.marshal(true)
+}
+
+// Check a signature made by the certified key.
+func (c *Certificate) Verify(data, sig []byte) error {
+	return c.Key.Verify(data, sig)
+}
+
+func (c *Certificate) marshal(signed bool) []byte {
+	var keyType string
+	var keyFields []byte
+	NewDecoder(c.Key.Marshal()).U32String(&keyType).Rest(&keyFields)
+
+	principals := NewEncoder()
+	for _, principal := range c.ValidPrincipals {
+		principals.U32String(principal)
+	}
+
+	p := NewEncoder().U32String(c.Type()).U32Bytes(c.Nonce).Bytes(keyFields).
+		U64(c.Serial).U32(c.CertType).U32String(c.KeyId).U32Bytes(principals.Out()).
+		U64(c.ValidAfter).U64(c.ValidBefore).
+		U32Bytes(marshalCertOptions(c.CriticalOptions)).U32Bytes(marshalCertOptions(c.Extensions)).
+		U32Bytes(c.Reserved).U32Bytes(c.SignatureKey.Marshal())
+	if signed {
+		p.U32Bytes(c.Signature)
+	}
+	return p.Out()
+}
+
+// Sign the certificate with the CA key. A random nonce is set if there is none.
+func (c *Certificate) SignCert(ca Signer) (err error) {
+	if len(c.Nonce) == 0 {
+		c.Nonce = rand(32)
+	}
+	c.SignatureKey = ca.PublicKey()
+	c.Signature, err = ca.Sign(c.marshal(false))
+	return
+}
+
+// Check the CA signature of the certificate.
+func (c *Certificate) CheckSignature() error {
+	if _, ok := c.SignatureKey.(*Certificate); ok {
+		return errors.New("certificate signed by a certificate")
+	}
+	return c.SignatureKey.Verify(c.marshal(false), c.Signature)
+}
+
+// Report whether t is within the validity period of the certificate.
+func (c *Certificate) ValidAt(t time.Time) bool {
+	if t.Before(time.Unix(0, 0)) {
+		return false
+	}
+	now := uint64(t.Unix())
+	return (now >= c.ValidAfter) && (now < c.ValidBefore)
+}
+
+// Report whether principal is listed in the certificate. An empty list
+// matches any principal.
+func (c *Certificate) HasPrincipal(principal string) bool {
+	if len(c.ValidPrincipals) == 0 {
+		return true
+	}
+	for _, p := range c.ValidPrincipals {
+		if p == principal {
+			return true
+		}
+	}
+	return false
+}
+
+// Pair a certificate with the signer of its certified key, so that the
+// certificate is presented with publickey authentication.
+func NewCertSigner(cert *Certificate, signer Signer) (Signer, error) {
+	if !bytes.Equal(cert.Key.Marshal(), signer.PublicKey().Marshal()) {
+		return nil, errors.New("certificate does not match the private key")
+	}
+	return &certSigner{signer, cert}, nil
+}
+
+type certSigner struct {
+	Signer
+	cert *Certificate
+}
+
+func (s *certSigner) PublicKey() PublicKey {
+	return s.cert
+}
+
+// The certificate algorithm names follow the signature algorithm, e.g.
+// rsa-sha2-256-cert-v01@openssh.com (RFC8332/3.3).
+func (s *certSigner) Algorithm() string {
+	return s.Signer.Algorithm() + certSuffix
+}
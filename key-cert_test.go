@@ -0,0 +1,85 @@
+package ssh
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"testing"
+	"time"
+
+	xssh "golang.org/x/crypto/ssh"
+)
+
+func TestCertSigner(t *testing.T) {
+	_, caKey, _ := ed25519.GenerateKey(crand.Reader)
+	ca, err := NewSigner(caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userKey, _ := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	user, err := NewSigner(userKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := uint64(time.Now().Unix())
+	cert := &Certificate{
+		Key:             user.PublicKey(),
+		CertType:        UserCert,
+		KeyId:           "test",
+		ValidPrincipals: []string{"alice"},
+		ValidAfter:      now - 60,
+		ValidBefore:     now + 3600,
+		CriticalOptions: map[string]string{"force-command": "/bin/true"},
+		Extensions:      map[string]string{"permit-pty": ""},
+	}
+	if err := cert.SignCert(ca); err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.CheckSignature(); err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := ParseCertificate(cert.Marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(parsed.Marshal(), cert.Marshal()) || parsed.KeyId != "test" ||
+		parsed.CriticalOptions["force-command"] != "/bin/true" || !parsed.HasPrincipal("alice") ||
+		!parsed.ValidAt(time.Now()) || parsed.ValidAt(time.Now().Add(2*time.Hour)) {
+		t.Fatalf("%+v", parsed)
+	}
+
+	// the server trusts the CA and checks principals and options
+	caPub, err := xssh.ParsePublicKey(ca.PublicKey().Marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checker := &xssh.CertChecker{
+		SupportedCriticalOptions: []string{"force-command"},
+		IsUserAuthority:          func(k xssh.PublicKey) bool { return bytes.Equal(k.Marshal(), caPub.Marshal()) },
+	}
+	signer, err := NewCertSigner(cert, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		user string
+		ok   bool
+	}{{"alice", true}, {"bob", false}} {
+		cfg := &xssh.ServerConfig{PublicKeyCallback: checker.Authenticate}
+		client, err := NewClient(testServer(t, cfg, nil), &ClientOption{User: c.user, Signers: []Signer{signer}})
+		if (err == nil) != c.ok {
+			t.Fatal(c.user, err)
+		}
+		if client != nil {
+			client.Close()
+		}
+	}
+
+	if _, err := NewCertSigner(cert, ca); err == nil {
+		t.Fatal("certificate paired with the wrong key")
+	}
+}
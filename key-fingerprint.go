@@ -0,0 +1,118 @@
+package ssh
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// OpenSSH style SHA256 fingerprint, e.g. "SHA256:yKn5+Xkh5qJ+g3q8...".
+func FingerprintSHA256(key PublicKey) string {
+	sum := sha256.Sum256(key.Marshal())
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// OpenSSH style MD5 fingerprint, e.g. "MD5:75:0d:2c:fb:...".
+func FingerprintMD5(key PublicKey) string {
+	sum := md5.Sum(key.Marshal())
+	hexes := make([]string, len(sum))
+	for i, b := range sum {
+		hexes[i] = hex.EncodeToString([]byte{b})
+	}
+	return "MD5:" + strings.Join(hexes, ":")
+}
+
+// Size of the key in bits, as shown by ssh-keygen -l.
+func KeyBits(key PublicKey) int {
+	switch k := key.(type) {
+	case *Certificate:
+		return KeyBits(k.Key)
+	case *rsaPublicKey:
+		return k.N.BitLen()
+	case *ecdsaPublicKey:
+		return k.Curve.Params().BitSize
+	case ed25519PublicKey:
+		return 256
+	}
+	return 0
+}
+
+// Short key type name as shown by ssh-keygen, e.g. "RSA" or "ED25519-CERT".
+func keyTypeName(key PublicKey) string {
+	name := ""
+	switch k := key.(type) {
+	case *Certificate:
+		return keyTypeName(k.Key) + "-CERT"
+	case *rsaPublicKey:
+		name = "RSA"
+	case *ecdsaPublicKey:
+		name = "ECDSA"
+	case ed25519PublicKey:
+		name = "ED25519"
+	}
+	return name
+}
+
+// Render the SHA256 fingerprint of key as OpenSSH randomart (the "drunken
+// bishop" walk of ssh-keygen -lv).
+func RandomArt(key PublicKey) string {
+	const (
+		width, height = 17, 9
+		symbols       = " .o+=*BOX@%&#/^SE"
+		start, end    = len(symbols) - 2, len(symbols) - 1
+	)
+
+	var field [width][height]int
+	x, y := width/2, height/2
+	sum := sha256.Sum256(key.Marshal())
+	for _, b := range sum {
+		for i := 0; i < 4; i++ {
+			if b&1 != 0 {
+				x++
+			} else {
+				x--
+			}
+			if b&2 != 0 {
+				y++
+			} else {
+				y--
+			}
+			x = max(0, min(x, width-1))
+			y = max(0, min(y, height-1))
+			if field[x][y] < start-1 {
+				field[x][y]++
+			}
+			b >>= 2
+		}
+	}
+	field[width/2][height/2] = start
+	field[x][y] = end
+
+	border := func(title string) string {
+		if len(title) > width {
+			title = title[:width]
+		}
+		left := (width - len(title)) / 2
+		return "+" + strings.Repeat("-", left) + title + strings.Repeat("-", width-left-len(title)) + "+\n"
+	}
+
+	title := fmt.Sprintf("[%s %d]", keyTypeName(key), KeyBits(key))
+	if len(title) > width {
+		title = "[" + keyTypeName(key) + "]"
+	}
+
+	var art strings.Builder
+	art.WriteString(border(title))
+	for y := 0; y < height; y++ {
+		art.WriteByte('|')
+		for x := 0; x < width; x++ {
+			art.WriteByte(symbols[field[x][y]])
+		}
+		art.WriteString("|\n")
+	}
+	art.WriteString(border("[SHA256]"))
+	return strings.TrimSuffix(art.String(), "\n")
+}
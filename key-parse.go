@@ -0,0 +1,340 @@
+package ssh
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"hash"
+	"io/ioutil"
+	"math/big"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Returned when an encrypted private key is parsed without a passphrase callback.
+var ErrPassphraseRequired = errors.New("private key is encrypted, passphrase required")
+
+// Load a private key file, see ParsePrivateKey.
+func LoadPrivateKey(path string, passphrase func() (string, error)) (Signer, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePrivateKey(data, passphrase)
+}
+
+// Parse a PEM encoded private key in OpenSSH, PKCS#1 (RSA), SEC1 (EC) or
+// PKCS#8 format. The passphrase callback is only called for encrypted keys
+// and may be nil.
+func ParsePrivateKey(data []byte, passphrase func() (string, error)) (Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no private key found")
+	}
+
+	if block.Type == "OPENSSH PRIVATE KEY" {
+		return parseOpenSSHPrivateKey(block.Bytes, passphrase)
+	}
+
+	der := block.Bytes
+	if x509.IsEncryptedPEMBlock(block) || (block.Type == "ENCRYPTED PRIVATE KEY") {
+		if passphrase == nil {
+			return nil, ErrPassphraseRequired
+		}
+		pass, err := passphrase()
+		if err != nil {
+			return nil, err
+		}
+		if block.Type == "ENCRYPTED PRIVATE KEY" {
+			der, err = decryptPKCS8(der, []byte(pass))
+		} else {
+			der, err = x509.DecryptPEMBlock(block, []byte(pass))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var key crypto.PrivateKey
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(der)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(der)
+	case "PRIVATE KEY", "ENCRYPTED PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(der)
+	default:
+		return nil, errors.New("unsupported private key type: " + block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewSigner(key)
+}
+
+// Parse the openssh-key-v1 format (PROTOCOL.key in the OpenSSH sources).
+func parseOpenSSHPrivateKey(data []byte, passphrase func() (string, error)) (s Signer, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			s, err = nil, errors.New("malformed openssh private key")
+		}
+	}()
+
+	const magic = "openssh-key-v1\x00"
+	if !bytes.HasPrefix(data, []byte(magic)) {
+		return nil, errors.New("invalid openssh private key magic")
+	}
+
+	var cipherName, kdfName string
+	var kdfOptions, pubKey, private, tag []byte
+	var n uint32
+	NewDecoder(data[len(magic):]).
+		U32String(&cipherName).U32String(&kdfName).U32Bytes(&kdfOptions).
+		U32(&n).U32Bytes(&pubKey).U32Bytes(&private).Rest(&tag)
+	if n != 1 {
+		return nil, errors.New("openssh private key files with multiple keys are not supported")
+	}
+
+	if cipherName != "none" {
+		if passphrase == nil {
+			return nil, ErrPassphraseRequired
+		}
+		if kdfName != "bcrypt" {
+			return nil, errors.New("unsupported kdf: " + kdfName)
+		}
+
+		var salt []byte
+		var rounds uint32
+		NewDecoder(kdfOptions).U32Bytes(&salt).U32(&rounds).End()
+
+		pass, err := passphrase()
+		if err != nil {
+			return nil, err
+		}
+		private, err = decryptOpenSSHPrivateKey(cipherName, []byte(pass), salt, int(rounds), private, tag)
+		if err != nil {
+			return nil, err
+		}
+	} else if len(tag) > 0 {
+		return nil, errors.New("openssh private key has trailing data")
+	}
+
+	var check1, check2 uint32
+	var keyType, comment string
+	p := NewDecoder(private).U32(&check1).U32(&check2)
+	if check1 != check2 {
+		return nil, errors.New("incorrect passphrase")
+	}
+
+	var key crypto.PrivateKey
+	switch p.U32String(&keyType); keyType {
+	case "ssh-rsa":
+		var n, e, d, iqmp, p1, q *big.Int
+		p.MPInt(&n).MPInt(&e).MPInt(&d).MPInt(&iqmp).MPInt(&p1).MPInt(&q)
+		k := &rsa.PrivateKey{PublicKey: rsa.PublicKey{N: n, E: int(e.Int64())}, D: d, Primes: []*big.Int{p1, q}}
+		if err = k.Validate(); err != nil {
+			return nil, err
+		}
+		k.Precompute()
+		key = k
+
+	case "ecdsa-sha2-nistp256", "ecdsa-sha2-nistp384", "ecdsa-sha2-nistp521":
+		var curveName string
+		var q []byte
+		var d *big.Int
+		p.U32String(&curveName).U32Bytes(&q).MPInt(&d)
+		curve := ecdsaCurve(curveName)
+		if curve == nil {
+			return nil, errors.New("unsupported ecdsa curve: " + curveName)
+		}
+		x, y := elliptic.Unmarshal(curve, q)
+		if x == nil {
+			return nil, errors.New("invalid ecdsa point")
+		}
+		key = &ecdsa.PrivateKey{PublicKey: ecdsa.PublicKey{Curve: curve, X: x, Y: y}, D: d}
+
+	case "ssh-ed25519":
+		var pub, priv []byte
+		p.U32Bytes(&pub).U32Bytes(&priv)
+		if (len(priv) != ed25519.PrivateKeySize) || !bytes.Equal(priv[32:], pub) {
+			return nil, errors.New("invalid ed25519 private key")
+		}
+		key = ed25519.PrivateKey(append([]byte(nil), priv...))
+
+	default:
+		return nil, errors.New("unsupported private key type: " + keyType)
+	}
+
+	var padding []byte
+	p.U32String(&comment).Rest(&padding)
+	for i, b := range padding {
+		if int(b) != i+1 {
+			return nil, errors.New("invalid openssh private key padding")
+		}
+	}
+
+	if s, err = NewSigner(key); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(s.PublicKey().Marshal(), pubKey) {
+		return nil, errors.New("openssh private key does not match its public key")
+	}
+	return s, nil
+}
+
+func decryptOpenSSHPrivateKey(cipherName string, pass, salt []byte, rounds int, data, tag []byte) ([]byte, error) {
+	var keyLen, ivLen int
+	var gcm bool
+	switch cipherName {
+	case "aes128-ctr", "aes128-cbc":
+		keyLen, ivLen = 16, aes.BlockSize
+	case "aes192-ctr", "aes192-cbc":
+		keyLen, ivLen = 24, aes.BlockSize
+	case "aes256-ctr", "aes256-cbc":
+		keyLen, ivLen = 32, aes.BlockSize
+	case "aes128-gcm@openssh.com":
+		keyLen, ivLen, gcm = 16, 12, true
+	case "aes256-gcm@openssh.com":
+		keyLen, ivLen, gcm = 32, 12, true
+	default:
+		return nil, errors.New("unsupported private key cipher: " + cipherName)
+	}
+
+	k, err := bcryptPBKDF(pass, salt, rounds, keyLen+ivLen)
+	if err != nil {
+		return nil, err
+	}
+	block := newAES(k[:keyLen])
+	iv := k[keyLen:]
+
+	if gcm {
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		plain, err := aead.Open(nil, iv, append(append([]byte(nil), data...), tag...), nil)
+		if err != nil {
+			return nil, errors.New("incorrect passphrase")
+		}
+		return plain, nil
+	}
+
+	if (len(tag) > 0) || (len(data)%aes.BlockSize != 0) {
+		return nil, errors.New("invalid encrypted private key length")
+	}
+	plain := make([]byte, len(data))
+	if cipherName[len(cipherName)-3:] == "cbc" {
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, data)
+	} else {
+		cipher.NewCTR(block, iv).XORKeyStream(plain, data)
+	}
+	return plain, nil
+}
+
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+	oidAES128CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// Decrypt a PKCS#8 EncryptedPrivateKeyInfo protected with PBES2 (RFC8018),
+// the scheme used by "openssl pkcs8 -topk8" and OpenSSH.
+func decryptPKCS8(der, pass []byte) ([]byte, error) {
+	var info struct {
+		Algo pkix.AlgorithmIdentifier
+		Data []byte
+	}
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, err
+	}
+	if !info.Algo.Algorithm.Equal(oidPBES2) {
+		return nil, errors.New("unsupported pkcs8 encryption, only PBES2 is supported")
+	}
+
+	var params struct {
+		KDF, Scheme pkix.AlgorithmIdentifier
+	}
+	if _, err := asn1.Unmarshal(info.Algo.Parameters.FullBytes, &params); err != nil {
+		return nil, err
+	}
+	if !params.KDF.Algorithm.Equal(oidPBKDF2) {
+		return nil, errors.New("unsupported pkcs8 key derivation function")
+	}
+
+	var kdf struct {
+		Salt       []byte
+		Iterations int
+		KeyLength  int                      `asn1:"optional"`
+		PRF        pkix.AlgorithmIdentifier `asn1:"optional"`
+	}
+	if _, err := asn1.Unmarshal(params.KDF.Parameters.FullBytes, &kdf); err != nil {
+		return nil, err
+	}
+
+	var prf func() hash.Hash
+	switch {
+	case (len(kdf.PRF.Algorithm) == 0) || kdf.PRF.Algorithm.Equal(oidHMACWithSHA1):
+		prf = sha1.New
+	case kdf.PRF.Algorithm.Equal(oidHMACWithSHA256):
+		prf = sha256.New
+	case kdf.PRF.Algorithm.Equal(oidHMACWithSHA512):
+		prf = sha512.New
+	default:
+		return nil, errors.New("unsupported pkcs8 pbkdf2 prf")
+	}
+
+	var keyLen int
+	switch {
+	case params.Scheme.Algorithm.Equal(oidAES128CBC):
+		keyLen = 16
+	case params.Scheme.Algorithm.Equal(oidAES192CBC):
+		keyLen = 24
+	case params.Scheme.Algorithm.Equal(oidAES256CBC):
+		keyLen = 32
+	default:
+		return nil, errors.New("unsupported pkcs8 cipher")
+	}
+
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.Scheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, err
+	}
+	if (len(iv) != aes.BlockSize) || (len(info.Data) == 0) || (len(info.Data)%aes.BlockSize != 0) {
+		return nil, errors.New("invalid pkcs8 encrypted data")
+	}
+
+	plain := make([]byte, len(info.Data))
+	key := pbkdf2.Key(pass, kdf.Salt, kdf.Iterations, keyLen, prf)
+	cipher.NewCBCDecrypter(newAES(key), iv).CryptBlocks(plain, info.Data)
+
+	// strip and check the PKCS#7 padding
+	n := int(plain[len(plain)-1])
+	if (n == 0) || (n > aes.BlockSize) {
+		return nil, errors.New("incorrect passphrase")
+	}
+	for _, b := range plain[len(plain)-n:] {
+		if int(b) != n {
+			return nil, errors.New("incorrect passphrase")
+		}
+	}
+	return plain[:len(plain)-n], nil
+}
@@ -0,0 +1,103 @@
+package ssh
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Keys in testdata were made with ssh-keygen; the encrypted ones use the
+// passphrase "secret".
+func TestParsePrivateKey(t *testing.T) {
+	for _, c := range []struct {
+		file      string
+		encrypted bool
+	}{
+		{"openssh-ed25519", false},
+		{"openssh-ecdsa-aes256-ctr", true},
+		{"openssh-ed25519-aes256-gcm", true},
+		{"openssh-rsa-aes128-cbc", true},
+		{"pem-rsa", false},
+		{"pem-rsa-encrypted", true},
+		{"pem-ecdsa", false},
+		{"pkcs8-ecdsa", false},
+		{"pkcs8-rsa-encrypted", true},
+	} {
+		path := filepath.Join("testdata", c.file)
+		pub, err := os.ReadFile(path + ".pub")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, _, err := ParseAuthorizedKey(pub)
+		if err != nil {
+			t.Fatal(c.file, err)
+		}
+
+		s, err := LoadPrivateKey(path, func() (string, error) { return "secret", nil })
+		if err != nil {
+			t.Fatal(c.file, err)
+		}
+		if !bytes.Equal(s.PublicKey().Marshal(), want.Marshal()) {
+			t.Fatal(c.file, "public key differs")
+		}
+		sig, err := s.Sign([]byte("data"))
+		if err != nil {
+			t.Fatal(c.file, err)
+		}
+		if err := want.Verify([]byte("data"), sig); err != nil {
+			t.Fatal(c.file, err)
+		}
+
+		if !c.encrypted {
+			continue
+		}
+		if _, err := LoadPrivateKey(path, nil); err != ErrPassphraseRequired {
+			t.Fatal(c.file, err)
+		}
+		if _, err := LoadPrivateKey(path, func() (string, error) { return "wrong", nil }); err == nil {
+			t.Fatal(c.file, "wrong passphrase accepted")
+		}
+		failed := errors.New("no passphrase")
+		if _, err := LoadPrivateKey(path, func() (string, error) { return "", failed }); err != failed {
+			t.Fatal(c.file, err)
+		}
+	}
+}
+
+func TestParsePrivateKeyIncorrectPassphrase(t *testing.T) {
+	// the OpenSSH format and PKCS#8 tell a wrong passphrase by their checks
+	for _, file := range []string{"openssh-ecdsa-aes256-ctr", "openssh-ed25519-aes256-gcm", "openssh-rsa-aes128-cbc", "pkcs8-rsa-encrypted"} {
+		_, err := LoadPrivateKey(filepath.Join("testdata", file), func() (string, error) { return "wrong", nil })
+		if (err == nil) || (err.Error() != "incorrect passphrase") {
+			t.Fatal(file, err)
+		}
+	}
+}
+
+// Vectors of the OpenBSD bcrypt_pbkdf regression test.
+func TestBcryptPBKDF(t *testing.T) {
+	for _, c := range []struct {
+		rounds         int
+		password, salt string
+		key            string
+	}{
+		{12, "password", "salt", "1ae42c05d487bc02f64921a4ebe4ea93bcacfe135fda99974c06b7b01fae149a"},
+		{3, "passwordy\x00PASSWORD\x00", "salty\x00SALT\x00", "7f310bd3e78c3280c59ce4595211a2928e8d4ec744c1ed2efc9f764e3388e0ad"},
+		{8, "секретное слово", "посолить немножко", "8df43fc6fe131fc47f0c9e39224bd94c70b6fcc8ee8135faddf611" +
+			"56e6cb2733ea765f315a3e1e4afc35bf8687d189254c1e05a6fe80c0617f9183d67260d6a115c6c94e3603e2303fbb43a76a64523ffda686b1d4518543"},
+	} {
+		key, err := bcryptPBKDF([]byte(c.password), []byte(c.salt), c.rounds, len(c.key)/2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(key) != c.key {
+			t.Fatalf("%q: %x", c.password, key)
+		}
+	}
+	if _, err := bcryptPBKDF(nil, []byte("salt"), 1, 32); err == nil {
+		t.Fatal("empty passphrase accepted")
+	}
+}
@@ -0,0 +1,328 @@
+package ssh
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"strings"
+)
+
+// Public key as sent on the wire (RFC4253/6.6).
+type PublicKey interface {
+	// Key type, e.g. "ssh-rsa" or "ssh-ed25519".
+	Type() string
+
+	// Key blob in SSH wire format.
+	Marshal() []byte
+
+	// Check the signature blob (string algorithm, string signature) of data.
+	Verify(data, sig []byte) error
+}
+
+// Private key used to authenticate the client.
+type Signer interface {
+	PublicKey() PublicKey
+
+	// Public key algorithm name sent with the signature, e.g. "rsa-sha2-256".
+	Algorithm() string
+
+	// Sign data and return the signature blob in SSH wire format.
+	Sign(data []byte) ([]byte, error)
+}
+
+// Parse a public key blob in SSH wire format.
+func ParsePublicKey(blob []byte) (key PublicKey, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			key, err = nil, errors.New("malformed public key")
+		}
+	}()
+
+	var algo string
+	p := NewDecoder(blob).U32String(&algo)
+	if strings.HasSuffix(algo, certSuffix) {
+		cert, err := ParseCertificate(blob)
+		if err != nil {
+			return nil, err
+		}
+		return cert, nil
+	}
+
+	switch algo {
+	case "ssh-rsa":
+		var e, n *big.Int
+		p.MPInt(&e).MPInt(&n).End()
+		if !e.IsInt64() || (e.Int64() < 3) || (e.Int64() > 1<<31-1) {
+			return nil, errors.New("invalid rsa public exponent")
+		}
+		return &rsaPublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "ecdsa-sha2-nistp256", "ecdsa-sha2-nistp384", "ecdsa-sha2-nistp521":
+		var curveName string
+		var q []byte
+		p.U32String(&curveName).U32Bytes(&q).End()
+		curve := ecdsaCurve(curveName)
+		if (curve == nil) || (algo != "ecdsa-sha2-"+curveName) {
+			return nil, errors.New("ecdsa curve mismatch")
+		}
+		x, y := elliptic.Unmarshal(curve, q)
+		if x == nil {
+			return nil, errors.New("invalid ecdsa point")
+		}
+		return &ecdsaPublicKey{Curve: curve, X: x, Y: y}, nil
+
+	case "ssh-ed25519":
+		var k []byte
+		p.U32Bytes(&k).End()
+		if len(k) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid ed25519 public key")
+		}
+		return ed25519PublicKey(append([]byte(nil), k...)), nil
+	}
+	return nil, errors.New("unsupported public key type: " + algo)
+}
+
+// Parse a public key in the one line text format of id_*.pub files and
+// authorized_keys entries without options: type, base64 blob and an
+// optional comment.
+func ParseAuthorizedKey(line []byte) (key PublicKey, comment string, err error) {
+	fields := strings.Fields(string(line))
+	if len(fields) < 2 {
+		return nil, "", errors.New("invalid public key line")
+	}
+
+	blob, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return nil, "", err
+	}
+	if key, err = ParsePublicKey(blob); err != nil {
+		return nil, "", err
+	}
+	if key.Type() != fields[0] {
+		return nil, "", errors.New("public key type mismatch: " + fields[0])
+	}
+	return key, strings.Join(fields[2:], " "), nil
+}
+
+// Wrap a *rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey.
+func NewPublicKey(key crypto.PublicKey) (PublicKey, error) {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return (*rsaPublicKey)(k), nil
+	case *ecdsa.PublicKey:
+		if ecdsaCurveName(k.Curve) == "" {
+			return nil, errors.New("unsupported ecdsa curve")
+		}
+		return (*ecdsaPublicKey)(k), nil
+	case ed25519.PublicKey:
+		return ed25519PublicKey(k), nil
+	}
+	return nil, errors.New("unsupported public key")
+}
+
+// Wrap a *rsa.PrivateKey, *ecdsa.PrivateKey or ed25519.PrivateKey. RSA keys
+// sign with rsa-sha2-256 (RFC8332).
+func NewSigner(key crypto.PrivateKey) (Signer, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return &signer{(*rsaPublicKey)(&k.PublicKey), k, "rsa-sha2-256"}, nil
+	case *ecdsa.PrivateKey:
+		pub, err := NewPublicKey(&k.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		return &signer{pub, k, pub.Type()}, nil
+	case ed25519.PrivateKey:
+		return &signer{ed25519PublicKey(k.Public().(ed25519.PublicKey)), k, "ssh-ed25519"}, nil
+	case *ed25519.PrivateKey:
+		return NewSigner(*k)
+	}
+	return nil, errors.New("unsupported private key")
+}
+
+type signer struct {
+	pub  PublicKey
+	priv crypto.PrivateKey
+	algo string
+}
+
+func (s *signer) PublicKey() PublicKey {
+	return s.pub
+}
+
+func (s *signer) Algorithm() string {
+	return s.algo
+}
+
+func (s *signer) Sign(data []byte) ([]byte, error) {
+	var sig []byte
+	var err error
+	switch k := s.priv.(type) {
+	case *rsa.PrivateKey:
+		h := signatureHash(s.algo)
+		sig, err = rsa.SignPKCS1v15(cryptorand.Reader, k, h, hashData(h, data))
+	case *ecdsa.PrivateKey:
+		var r, t *big.Int
+		r, t, err = ecdsa.Sign(cryptorand.Reader, k, hashData(ecdsaHash(k.Curve), data))
+		if err == nil {
+			sig = NewEncoder().MPInt(r).MPInt(t).Out()
+		}
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewEncoder().U32String(s.algo).U32Bytes(sig).Out(), nil
+}
+
+type rsaPublicKey rsa.PublicKey
+
+func (k *rsaPublicKey) Type() string {
+	return "ssh-rsa"
+}
+
+func (k *rsaPublicKey) Marshal() []byte {
+	return NewEncoder().U32String("ssh-rsa").MPInt(big.NewInt(int64(k.E))).MPInt(k.N).Out()
+}
+
+func (k *rsaPublicKey) Verify(data, sig []byte) error {
+	algo, blob, err := parseSignature(sig)
+	if err != nil {
+		return err
+	}
+	h := signatureHash(algo)
+	if (h == 0) || ((algo != "ssh-rsa") && (algo != "rsa-sha2-256") && (algo != "rsa-sha2-512")) {
+		return errors.New("unexpected signature algorithm: " + algo)
+	}
+	return rsa.VerifyPKCS1v15((*rsa.PublicKey)(k), h, hashData(h, data), blob)
+}
+
+type ecdsaPublicKey ecdsa.PublicKey
+
+func (k *ecdsaPublicKey) Type() string {
+	return "ecdsa-sha2-" + ecdsaCurveName(k.Curve)
+}
+
+func (k *ecdsaPublicKey) Marshal() []byte {
+	return NewEncoder().U32String(k.Type()).U32String(ecdsaCurveName(k.Curve)).U32Bytes(elliptic.Marshal(k.Curve, k.X, k.Y)).Out()
+}
+
+func (k *ecdsaPublicKey) Verify(data, sig []byte) (err error) {
+	algo, blob, err := parseSignature(sig)
+	if err != nil {
+		return
+	}
+	if algo != k.Type() {
+		return errors.New("unexpected signature algorithm: " + algo)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.New("malformed ecdsa signature")
+		}
+	}()
+
+	var r, s *big.Int
+	NewDecoder(blob).MPInt(&r).MPInt(&s).End()
+	if !ecdsa.Verify((*ecdsa.PublicKey)(k), hashData(ecdsaHash(k.Curve), data), r, s) {
+		err = errors.New("ecdsa signature verification failed")
+	}
+	return
+}
+
+type ed25519PublicKey []byte
+
+func (k ed25519PublicKey) Type() string {
+	return "ssh-ed25519"
+}
+
+func (k ed25519PublicKey) Marshal() []byte {
+	return NewEncoder().U32String("ssh-ed25519").U32Bytes(k).Out()
+}
+
+func (k ed25519PublicKey) Verify(data, sig []byte) error {
+	algo, blob, err := parseSignature(sig)
+	if err != nil {
+		return err
+	}
+	if algo != "ssh-ed25519" {
+		return errors.New("unexpected signature algorithm: " + algo)
+	}
+	if (len(blob) != ed25519.SignatureSize) || !ed25519.Verify(ed25519.PublicKey(k), data, blob) {
+		return errors.New("ed25519 signature verification failed")
+	}
+	return nil
+}
+
+// Split a signature blob into its algorithm name and signature data.
+func parseSignature(sig []byte) (algo string, blob []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.New("malformed signature")
+		}
+	}()
+
+	NewDecoder(sig).U32String(&algo).U32Bytes(&blob).End()
+	return
+}
+
+func signatureHash(algo string) crypto.Hash {
+	switch algo {
+	case "ssh-rsa":
+		return crypto.SHA1
+	case "rsa-sha2-256":
+		return crypto.SHA256
+	case "rsa-sha2-512":
+		return crypto.SHA512
+	}
+	return 0
+}
+
+func hashData(h crypto.Hash, data []byte) []byte {
+	d := h.New()
+	d.Write(data)
+	return d.Sum(nil)
+}
+
+func ecdsaCurve(name string) elliptic.Curve {
+	switch name {
+	case "nistp256":
+		return elliptic.P256()
+	case "nistp384":
+		return elliptic.P384()
+	case "nistp521":
+		return elliptic.P521()
+	}
+	return nil
+}
+
+func ecdsaCurveName(curve elliptic.Curve) string {
+	switch curve {
+	case elliptic.P256():
+		return "nistp256"
+	case elliptic.P384():
+		return "nistp384"
+	case elliptic.P521():
+		return "nistp521"
+	}
+	return ""
+}
+
+func ecdsaHash(curve elliptic.Curve) crypto.Hash {
+	switch curve.Params().BitSize {
+	case 256:
+		return crypto.SHA256
+	case 384:
+		return crypto.SHA384
+	}
+	return crypto.SHA512
+}
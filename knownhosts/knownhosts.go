@@ -0,0 +1,320 @@
+// Package knownhosts reads OpenSSH known_hosts files and checks server host
+// keys against them.
+package knownhosts
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/albertjin/ssh"
+)
+
+// Line markers.
+const (
+	CertAuthority = "@cert-authority"
+	Revoked       = "@revoked"
+)
+
+// A parsed known_hosts entry.
+type Line struct {
+	File string
+	Line int
+
+	// Empty, CertAuthority or Revoked.
+	Marker string
+
+	// Host patterns as written in the file, or a single hashed "|1|" entry.
+	Hosts []string
+
+	Key     ssh.PublicKey
+	Comment string
+}
+
+func (l *Line) String() string {
+	return fmt.Sprintf("%s:%d", l.File, l.Line)
+}
+
+// The host is not listed in any known_hosts file.
+type UnknownHostError struct {
+	Host string
+}
+
+func (e *UnknownHostError) Error() string {
+	return "knownhosts: unknown host " + e.Host
+}
+
+// The host is listed, but with other keys of the same type.
+type KeyMismatchError struct {
+	Host string
+	Want []*Line
+}
+
+func (e *KeyMismatchError) Error() string {
+	where := make([]string, len(e.Want))
+	for i, l := range e.Want {
+		where[i] = l.String()
+	}
+	return fmt.Sprintf("knownhosts: host key mismatch for %s (known keys at %s)", e.Host, strings.Join(where, ", "))
+}
+
+// The key is marked @revoked.
+type RevokedKeyError struct {
+	Revoked *Line
+}
+
+func (e *RevokedKeyError) Error() string {
+	return "knownhosts: host key is revoked at " + e.Revoked.String()
+}
+
+// Set of known_hosts entries read from one or more files.
+type DB struct {
+	mutex sync.RWMutex
+	lines []*Line
+}
+
+// Read the given known_hosts files. Missing files are skipped.
+func New(files ...string) (*DB, error) {
+	db := &DB{}
+	for _, file := range files {
+		f, err := os.Open(file)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		err = db.Read(f, file)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// Add the entries read from r; file names the source in Line.
+func (db *DB) Read(r io.Reader, file string) error {
+	var lines []*Line
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		l, err := parseLine(scanner.Bytes())
+		if err != nil {
+			ssh.Log(5, "knownhosts: %s:%d: %v", file, n, err)
+			continue
+		}
+		if l != nil {
+			l.File, l.Line = file, n
+			lines = append(lines, l)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	db.mutex.Lock()
+	db.lines = append(db.lines, lines...)
+	db.mutex.Unlock()
+	return nil
+}
+
+func parseLine(b []byte) (*Line, error) {
+	b = bytes.TrimSpace(b)
+	if (len(b) == 0) || (b[0] == '#') {
+		return nil, nil
+	}
+
+	l := &Line{}
+	if b[0] == '@' {
+		var marker []byte
+		marker, b = nextField(b)
+		l.Marker = string(marker)
+		if (l.Marker != CertAuthority) && (l.Marker != Revoked) {
+			return nil, errors.New("unknown marker " + l.Marker)
+		}
+	}
+
+	hosts, b := nextField(b)
+	if len(hosts) == 0 {
+		return nil, errors.New("missing host patterns")
+	}
+	l.Hosts = strings.Split(string(hosts), ",")
+
+	var err error
+	l.Key, l.Comment, err = ssh.ParseAuthorizedKey(b)
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func nextField(b []byte) (field, rest []byte) {
+	if i := bytes.IndexAny(b, " \t"); i >= 0 {
+		return b[:i], bytes.TrimLeft(b[i:], " \t")
+	}
+	return b, nil
+}
+
+// Check the host key presented by address, "host" or "host:port". As with
+// OpenSSH, only known keys of the same type as key are a mismatch; a host
+// listed with other key types only is unknown.
+func (db *DB) Check(address string, key ssh.PublicKey) error {
+	host := Normalize(address)
+
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	if l := db.revoked(key); l != nil {
+		return &RevokedKeyError{l}
+	}
+
+	if cert, ok := key.(*ssh.Certificate); ok && (cert.CertType == ssh.HostCert) {
+		if db.checkCert(address, host, cert) {
+			return nil
+		}
+		key = cert.Key
+	}
+
+	var want []*Line
+	blob := key.Marshal()
+	for _, l := range db.lines {
+		if (len(l.Marker) > 0) || !l.match(host) {
+			continue
+		}
+		if bytes.Equal(l.Key.Marshal(), blob) {
+			return nil
+		}
+		if l.Key.Type() == key.Type() {
+			want = append(want, l)
+		}
+	}
+	if len(want) == 0 {
+		return &UnknownHostError{host}
+	}
+	return &KeyMismatchError{host, want}
+}
+
+func (db *DB) revoked(key ssh.PublicKey) *Line {
+	keys := [][]byte{key.Marshal()}
+	if cert, ok := key.(*ssh.Certificate); ok {
+		keys = append(keys, cert.Key.Marshal(), cert.SignatureKey.Marshal())
+	}
+	for _, l := range db.lines {
+		if l.Marker != Revoked {
+			continue
+		}
+		for _, k := range keys {
+			if bytes.Equal(l.Key.Marshal(), k) {
+				return l
+			}
+		}
+	}
+	return nil
+}
+
+// A host certificate is accepted when it is signed by a @cert-authority for
+// the host, currently valid and lists the bare host name as a principal.
+func (db *DB) checkCert(address, host string, cert *ssh.Certificate) bool {
+	name := address
+	if h, _, err := splitHostPort(address); err == nil {
+		name = h
+	}
+	if !cert.ValidAt(time.Now()) || (len(cert.ValidPrincipals) == 0) || !cert.HasPrincipal(strings.ToLower(name)) {
+		return false
+	}
+	if cert.CheckSignature() != nil {
+		return false
+	}
+
+	ca := cert.SignatureKey.Marshal()
+	for _, l := range db.lines {
+		if (l.Marker == CertAuthority) && l.match(host) && bytes.Equal(l.Key.Marshal(), ca) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check the host key in info, for ssh.ClientOption.CheckHostKey. The host is
+// info.Host, or the remote address if Host is empty.
+func (db *DB) CheckHostKey(info *ssh.HostKeyInfo) error {
+	address := info.Host
+	if (len(address) == 0) && (info.RemoteAddr != nil) {
+		address = info.RemoteAddr.String()
+	}
+	return db.Check(address, info.Key)
+}
+
+// Return a callback for ssh.ClientOption.UpdateHostKeys that appends the
+// proven keys not yet known for the host to file, so that connections keep
+// working after the server rotates its host keys. Revoked keys are skipped.
+func (db *DB) AppendHostKeys(file string, hashed bool) func(host string, keys []ssh.PublicKey) {
+	return func(host string, keys []ssh.PublicKey) {
+		if len(host) == 0 {
+			return
+		}
+		for _, key := range keys {
+			switch db.Check(host, key).(type) {
+			case *UnknownHostError, *KeyMismatchError:
+				if err := db.Append(file, host, key, hashed); err != nil {
+					ssh.Log(5, "knownhosts: %v", err)
+				}
+			}
+		}
+	}
+}
+
+// Append an entry for address to file and to the database, e.g. to trust a
+// host on first use. With hashed the host name is stored as a "|1|" hash. The
+// file and its directory are created if needed.
+func (db *DB) Append(file, address string, key ssh.PublicKey, hashed bool) error {
+	host := Normalize(address)
+	if hashed {
+		host = HashHostname(host)
+	}
+	text := host + " " + key.Type() + " " + base64.StdEncoding.EncodeToString(key.Marshal()) + "\n"
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(file, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		return err
+	}
+
+	// keep entries on their own lines if the file lacks a final newline
+	n := bytes.Count(content, []byte("\n")) + 1
+	if (len(content) > 0) && (content[len(content)-1] != '\n') {
+		text = "\n" + text
+		n++
+	}
+
+	// a single write to an O_APPEND file does not interleave with others
+	if _, err := f.WriteString(text); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+
+	db.lines = append(db.lines, &Line{File: file, Line: n, Hosts: []string{host}, Key: key})
+	return nil
+}
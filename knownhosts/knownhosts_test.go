@@ -0,0 +1,185 @@
+package knownhosts
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/albertjin/ssh"
+)
+
+func newSigner(t *testing.T, kind string) ssh.Signer {
+	var key interface{}
+	switch kind {
+	case "ed25519":
+		_, key, _ = ed25519.GenerateKey(rand.Reader)
+	case "ecdsa":
+		key, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "rsa":
+		key, _ = rsa.GenerateKey(rand.Reader, 2048)
+	}
+	s, err := ssh.NewSigner(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func authorized(key ssh.PublicKey) string {
+	return key.Type() + " " + base64.StdEncoding.EncodeToString(key.Marshal())
+}
+
+func hostCert(t *testing.T, key ssh.PublicKey, ca ssh.Signer, principal string) *ssh.Certificate {
+	now := uint64(time.Now().Unix())
+	cert := &ssh.Certificate{Key: key, CertType: ssh.HostCert, ValidPrincipals: []string{principal}, ValidAfter: now - 60, ValidBefore: now + 3600}
+	if err := cert.SignCert(ca); err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestCheck(t *testing.T) {
+	plain, port, hashed, wild := newSigner(t, "ed25519").PublicKey(), newSigner(t, "ed25519").PublicKey(), newSigner(t, "ed25519").PublicKey(), newSigner(t, "ed25519").PublicKey()
+	other, rsaKey, revoked := newSigner(t, "ed25519").PublicKey(), newSigner(t, "rsa").PublicKey(), newSigner(t, "ecdsa").PublicKey()
+	ca, otherCA := newSigner(t, "ed25519"), newSigner(t, "ed25519")
+
+	text := strings.Join([]string{
+		"# comment",
+		"example.com,192.0.2.1 " + authorized(plain) + " comment",
+		"[example.com]:2222 " + authorized(port),
+		HashHostname("hashed.example") + " " + authorized(hashed),
+		"*.wild.example,!bad.wild.example " + authorized(wild),
+		"@cert-authority *.ca.example " + authorized(ca.PublicKey()),
+		"@revoked * " + authorized(revoked),
+		"bad line",
+	}, "\n")
+	db := &DB{}
+	if err := db.Read(strings.NewReader(text), "known_hosts"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		address string
+		key     ssh.PublicKey
+		want    string
+	}{
+		{"example.com", plain, ""},
+		{"EXAMPLE.com:22", plain, ""},
+		{"192.0.2.1", plain, ""},
+		{"example.com:2222", port, ""},
+		{"example.com:2222", plain, "mismatch"},
+		{"example.com", other, "mismatch"},
+		// only ed25519 is known for the host, so an rsa key is new
+		{"example.com", rsaKey, "unknown"},
+		{"example.org", plain, "unknown"},
+		{"hashed.example", hashed, ""},
+		{"hashed.example:2222", hashed, "unknown"},
+		{"a.wild.example", wild, ""},
+		{"bad.wild.example", wild, "unknown"},
+		{"example.com", revoked, "revoked"},
+		{"h.ca.example", hostCert(t, other, ca, "h.ca.example"), ""},
+		{"h.ca.example", hostCert(t, other, ca, "x.ca.example"), "unknown"},
+		{"h.ca.example", hostCert(t, other, otherCA, "h.ca.example"), "unknown"},
+		{"example.com", hostCert(t, plain, otherCA, "example.com"), ""},
+		{"h.ca.example", hostCert(t, revoked, ca, "h.ca.example"), "revoked"},
+	} {
+		err := db.Check(c.address, c.key)
+		got := ""
+		switch e := err.(type) {
+		case nil:
+		case *UnknownHostError:
+			got = "unknown"
+		case *KeyMismatchError:
+			got = "mismatch"
+			if (len(e.Want) != 1) || (e.Want[0].String() != "known_hosts:2") && (e.Want[0].String() != "known_hosts:3") {
+				t.Error(c.address, err)
+			}
+		case *RevokedKeyError:
+			got = "revoked"
+		default:
+			t.Fatal(c.address, err)
+		}
+		if got != c.want {
+			t.Errorf("%v %v: %v", c.address, c.key.Type(), err)
+		}
+	}
+}
+
+func TestAppend(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "ssh", "known_hosts")
+	db, err := New(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, key2 := newSigner(t, "ed25519").PublicKey(), newSigner(t, "ecdsa").PublicKey()
+	if _, ok := db.Check("example.com", key).(*UnknownHostError); !ok {
+		t.Fatal("not unknown")
+	}
+	if err := db.Append(file, "Example.com:22", key, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Append(file, "example.com:2222", key2, true); err != nil {
+		t.Fatal(err)
+	}
+	content, _ := os.ReadFile(file)
+	lines := strings.Split(string(content), "\n")
+	if (len(lines) != 3) || (lines[0] != "example.com "+authorized(key)) || !strings.HasPrefix(lines[1], "|1|") {
+		t.Fatalf("%q", content)
+	}
+
+	// entries are seen by the database and by a new one reading the file
+	db2, err := New(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []*DB{db, db2} {
+		if (d.Check("example.com", key) != nil) || (d.Check("example.com:2222", key2) != nil) {
+			t.Fatal("appended keys not found")
+		}
+		if d.Check("example.com", key2) == nil {
+			t.Fatal("key of another port accepted")
+		}
+	}
+
+	// a file without a final newline gets one before the entry
+	os.WriteFile(file, []byte("example.net "+authorized(key)), 0600)
+	db, _ = New(file)
+	if err := db.Append(file, "example.org", key, false); err != nil {
+		t.Fatal(err)
+	}
+	db2, _ = New(file)
+	if (db2.Check("example.net", key) != nil) || (db2.Check("example.org", key) != nil) {
+		content, _ = os.ReadFile(file)
+		t.Fatalf("%q", content)
+	}
+}
+
+func TestAppendHostKeys(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "known_hosts")
+	known, added, revoked := newSigner(t, "ed25519").PublicKey(), newSigner(t, "ecdsa").PublicKey(), newSigner(t, "rsa").PublicKey()
+	os.WriteFile(file, []byte("example.com "+authorized(known)+"\n@revoked * "+authorized(revoked)+"\n"), 0600)
+	db, err := New(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the server proves an ed25519 key already known and an ecdsa key of a
+	// type the file does not list yet
+	db.AppendHostKeys(file, false)("example.com", []ssh.PublicKey{known, added, revoked})
+	content, _ := os.ReadFile(file)
+	if n := strings.Count(string(content), "\n"); n != 3 {
+		t.Fatalf("%q", content)
+	}
+	db, _ = New(file)
+	if (db.Check("example.com", known) != nil) || (db.Check("example.com", added) != nil) {
+		t.Fatal("rotated keys not accepted")
+	}
+}
@@ -0,0 +1,119 @@
+package knownhosts
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"net"
+	"strings"
+)
+
+// Write address the way OpenSSH stores it in known_hosts: the lower cased
+// host name for port 22, "[host]:port" for other ports.
+func Normalize(address string) string {
+	host, port, err := splitHostPort(address)
+	if err != nil {
+		host, port = address, ""
+	}
+	host = strings.ToLower(host)
+	if (len(port) == 0) || (port == "22") {
+		return host
+	}
+	return "[" + host + "]:" + port
+}
+
+func splitHostPort(address string) (host, port string, err error) {
+	if strings.HasPrefix(address, "[") && strings.HasSuffix(address, "]") {
+		return address[1 : len(address)-1], "", nil
+	}
+	return net.SplitHostPort(address)
+}
+
+// Hash a normalized host name as a "|1|salt|hash" entry
+// (HashKnownHosts in ssh_config).
+func HashHostname(host string) string {
+	salt := make([]byte, sha1.Size)
+	if _, err := rand.Read(salt); err != nil {
+		panic(err)
+	}
+	return "|1|" + base64.StdEncoding.EncodeToString(salt) + "|" + base64.StdEncoding.EncodeToString(hashHost(salt, host))
+}
+
+func hashHost(salt []byte, host string) []byte {
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(host))
+	return mac.Sum(nil)
+}
+
+func matchHashed(entry, host string) bool {
+	parts := strings.Split(entry, "|")
+	if (len(parts) != 4) || (parts[1] != "1") {
+		return false
+	}
+	salt, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	hash, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(hashHost(salt, host), hash)
+}
+
+// Report whether the normalized host matches the line's patterns. A matching
+// negated pattern ("!pattern") excludes the host even if others match.
+func (l *Line) match(host string) bool {
+	if (len(l.Hosts) == 1) && strings.HasPrefix(l.Hosts[0], "|") {
+		return matchHashed(l.Hosts[0], host)
+	}
+
+	matched := false
+	for _, pattern := range l.Hosts {
+		negated := strings.HasPrefix(pattern, "!")
+		if negated {
+			pattern = pattern[1:]
+		}
+		if wildcardMatch(strings.ToLower(pattern), host) {
+			if negated {
+				return false
+			}
+			matched = true
+		}
+	}
+	return matched
+}
+
+// Match s against a pattern with "*" (any sequence) and "?" (any character).
+func wildcardMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for pattern = pattern[1:]; (len(pattern) > 0) && (pattern[0] == '*'); {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := range s {
+				if wildcardMatch(pattern, s[i:]) {
+					return true
+				}
+			}
+			return false
+
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+
+		default:
+			if (len(s) == 0) || (s[0] != pattern[0]) {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return len(s) == 0
+}
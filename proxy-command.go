@@ -0,0 +1,205 @@
+package ssh
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bytes of the command's standard error kept for diagnostics.
+const commandStderrTail = 4096
+
+// Transport over the standard input and output of a local command, like
+// OpenSSH's ProxyCommand, to pass to NewClient.
+type CommandConn struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+	stderr tailWriter
+
+	// Reaping the command, once; err is its failure.
+	once sync.Once
+	err  error
+
+	mutex  sync.Mutex
+	closed bool
+}
+
+// The proxy command failed; Stderr is the end of its error output.
+type CommandError struct {
+	Err    error
+	Stderr string
+}
+
+func (e *CommandError) Error() string {
+	if s := strings.TrimSpace(e.Stderr); len(s) > 0 {
+		return "proxy command: " + e.Err.Error() + ": " + s
+	}
+	return "proxy command: " + e.Err.Error()
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// Characters the shell would interpret, refused in the host and user as
+// OpenSSH does.
+const shellMetachars = "'`\"$\\;&|<>(){}[]*?!#~%^ \t\r\n"
+
+// Start command with "sh -c" ("cmd /c" on Windows) after replacing %h, %p and
+// %r with host, port and user, and %% with %. The command connects its
+// standard input and output to the server, e.g. "nc -X connect -x proxy:3128
+// %h %p". A host or user with shell metacharacters, or starting with '-', is
+// an error.
+func ProxyCommand(command, host string, port int, user string) (*CommandConn, error) {
+	if !validCommandName(host) {
+		return nil, errors.New("proxy command: invalid host name")
+	}
+	if !validCommandName(user) {
+		return nil, errors.New("proxy command: invalid user name")
+	}
+	command = expandProxyCommand(command, host, port, user)
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/c", command)
+	} else {
+		// exec, so Close kills the command rather than the shell
+		cmd = exec.Command("/bin/sh", "-c", "exec "+command)
+	}
+	conn := &CommandConn{cmd: cmd}
+	cmd.Stderr = &conn.stderr
+	// children of the command may hold its stderr open
+	cmd.WaitDelay = time.Second
+
+	var err error
+	if conn.stdin, err = cmd.StdinPipe(); err != nil {
+		return nil, err
+	}
+	if conn.stdout, err = cmd.StdoutPipe(); err != nil {
+		return nil, err
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+func validCommandName(name string) bool {
+	return !strings.HasPrefix(name, "-") && !strings.ContainsAny(name, shellMetachars)
+}
+
+func expandProxyCommand(command, host string, port int, user string) string {
+	var b strings.Builder
+	for i := 0; i < len(command); i++ {
+		if (command[i] != '%') || (i+1 == len(command)) {
+			b.WriteByte(command[i])
+			continue
+		}
+		i++
+		switch command[i] {
+		case 'h':
+			b.WriteString(host)
+		case 'p':
+			b.WriteString(strconv.Itoa(port))
+		case 'r':
+			b.WriteString(user)
+		case '%':
+			b.WriteByte('%')
+		default:
+			b.WriteByte('%')
+			b.WriteByte(command[i])
+		}
+	}
+	return b.String()
+}
+
+func (c *CommandConn) isClosed() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.closed
+}
+
+// Read the command's output. When it ends the command is reaped, and if it
+// failed the *CommandError is returned instead of io.EOF.
+func (c *CommandConn) Read(buf []byte) (n int, err error) {
+	n, err = c.stdout.Read(buf)
+	switch {
+	case err == nil:
+	case c.isClosed():
+		err = net.ErrClosed
+	case err == io.EOF:
+		if e := c.wait(); e != nil {
+			err = e
+		}
+	}
+	return
+}
+
+func (c *CommandConn) Write(data []byte) (n int, err error) {
+	n, err = c.stdin.Write(data)
+	if (err != nil) && c.isClosed() {
+		err = net.ErrClosed
+	}
+	return
+}
+
+// Close the command's input, kill it and reap it. A second Close returns
+// net.ErrClosed.
+func (c *CommandConn) Close() error {
+	c.mutex.Lock()
+	closed := c.closed
+	c.closed = true
+	c.mutex.Unlock()
+
+	if closed {
+		return net.ErrClosed
+	}
+	c.stdin.Close()
+	c.cmd.Process.Kill()
+	c.wait()
+	return nil
+}
+
+// The end of what the command wrote to its standard error, e.g. to explain
+// why NewClient failed.
+func (c *CommandConn) Stderr() string {
+	return c.stderr.String()
+}
+
+func (c *CommandConn) wait() error {
+	c.once.Do(func() {
+		if err := c.cmd.Wait(); err != nil {
+			c.err = &CommandError{Err: err, Stderr: c.Stderr()}
+		}
+	})
+	return c.err
+}
+
+// Keeps the last commandStderrTail bytes written.
+type tailWriter struct {
+	mutex sync.Mutex
+	data  []byte
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	w.data = append(w.data, p...)
+	if n := len(w.data) - commandStderrTail; n > 0 {
+		w.data = append([]byte(nil), w.data[n:]...)
+	}
+	w.mutex.Unlock()
+	return len(p), nil
+}
+
+func (w *tailWriter) String() string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return string(w.data)
+}
@@ -0,0 +1,114 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Stands in for nc when the test binary is run by ProxyCommand: connects to
+// the host and port after "--" and copies between them and stdio.
+func TestHelperNC(t *testing.T) {
+	if os.Getenv("SSH_HELPER_NC") == "" {
+		return
+	}
+	var args []string
+	for i, a := range os.Args {
+		if a == "--" {
+			args = os.Args[i+1:]
+		}
+	}
+	fmt.Fprintln(os.Stderr, "connecting to", args[0], args[1], "as", args[2])
+	c, err := net.Dial("tcp", net.JoinHostPort(args[0], args[1]))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(3)
+	}
+	go func() {
+		io.Copy(c, os.Stdin)
+		c.(*net.TCPConn).CloseWrite()
+	}()
+	io.Copy(os.Stdout, c)
+	os.Exit(0)
+}
+
+func TestExpandProxyCommand(t *testing.T) {
+	if s := expandProxyCommand("a %h:%p %r %% %x %", "h", 22, "u"); s != "a h:22 u % %x %" {
+		t.Fatal(s)
+	}
+	for _, c := range []struct{ host, user string }{
+		{"h;touch x", "u"}, {"$(id)", "u"}, {"-oProxyCommand=x", "u"}, {"h", "u`id`"}, {"h", "a b"},
+	} {
+		if _, err := ProxyCommand("nc %h %p", c.host, 22, c.user); err == nil {
+			t.Fatal(c)
+		}
+	}
+}
+
+func TestProxyCommand(t *testing.T) {
+	t.Setenv("SSH_HELPER_NC", "1")
+	ln := testListener(t, defaultServerConfig, nil)
+	defer ln.Close()
+	addr := ln.Addr().(*net.TCPAddr)
+	command := os.Args[0] + " -test.run=TestHelperNC -- %h %p %r"
+
+	conn, err := ProxyCommand(command, "127.0.0.1", addr.Port, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := testPassword("p")
+	o.Host = addr.String()
+	c, err := NewClient(conn, o)
+	if err != nil {
+		t.Fatal(err, conn.Stderr())
+	}
+	if ok, _, err := c.SendRequest("nothing@test", true, nil); ok || (err != nil) {
+		t.Fatal(ok, err)
+	}
+	closeTest(t, c)
+	if (conn.cmd.ProcessState == nil) || !strings.Contains(conn.Stderr(), "as alice") {
+		t.Fatal("command not reaped", conn.Stderr())
+	}
+	if err := conn.Close(); !errors.Is(err, net.ErrClosed) {
+		t.Fatal(err)
+	}
+
+	// a failing command reports its stderr
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	closed.Close()
+	conn, err = ProxyCommand(command, "127.0.0.1", closed.Addr().(*net.TCPAddr).Port, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = conn.Read(make([]byte, 10))
+	var ce *CommandError
+	if !errors.As(err, &ce) || !strings.Contains(ce.Stderr, "refused") || !strings.Contains(err.Error(), "exit status 3") {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func TestProxyCommandKill(t *testing.T) {
+	t.Setenv("SSH_HELPER_NC", "1")
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer ln.Close()
+	// the helper waits on a connection that is never answered
+	conn, err := ProxyCommand(os.Args[0]+" -test.run=TestHelperNC -- 127.0.0.1 "+strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)+" u", "", 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	conn.Close()
+	if (time.Since(start) > 2*time.Second) || (conn.cmd.ProcessState == nil) {
+		t.Fatal("command not killed")
+	}
+	if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, net.ErrClosed) {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,52 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package ssh
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// Resize notifications are not supported on this platform.
+func notifyResize(resized func()) (stop func()) {
+	return func() {}
+}
+
+// Suspending is not supported on this platform.
+func suspendProcess() {
+}
+
+// A read of the local terminal pending when the session ends cannot be
+// interrupted here: it still takes the next input, which is dropped.
+const inputInterruptible = false
+
+type inputReader struct {
+	f *os.File
+
+	mutex    sync.Mutex
+	canceled bool
+}
+
+func newInputReader(f *os.File) (*inputReader, error) {
+	return &inputReader{f: f}, nil
+}
+
+func (r *inputReader) Read(buf []byte) (int, error) {
+	n, err := r.f.Read(buf)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.canceled {
+		return 0, io.EOF
+	}
+	return n, err
+}
+
+func (r *inputReader) cancel() {
+	r.mutex.Lock()
+	r.canceled = true
+	r.mutex.Unlock()
+}
+
+func (r *inputReader) close() {
+}
@@ -0,0 +1,92 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package ssh
+
+import (
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// Call resized on SIGWINCH until the returned stop function is called.
+func notifyResize(resized func()) (stop func()) {
+	signals := make(chan os.Signal, 1)
+	done := make(chan int)
+	signal.Notify(signals, unix.SIGWINCH)
+	go func() {
+		for {
+			select {
+			case <-signals:
+				resized()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+// Stop the process until it is continued, e.g. by the shell's fg.
+func suspendProcess() {
+	unix.Kill(unix.Getpid(), unix.SIGTSTP)
+}
+
+// Reads of the local terminal end once cancel is called, leaving later input
+// to the caller of Session.Interactive.
+const inputInterruptible = true
+
+type inputReader struct {
+	f  *os.File
+	fd int
+
+	// Becomes readable on cancel.
+	stop, stopWrite *os.File
+	once            sync.Once
+}
+
+func newInputReader(f *os.File) (*inputReader, error) {
+	stop, stopWrite, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	return &inputReader{f: f, fd: int(f.Fd()), stop: stop, stopWrite: stopWrite}, nil
+}
+
+// Wait until f or stop is readable, so no input is taken after cancel. Poll
+// rather than select, which cannot watch descriptors past FD_SETSIZE.
+func (r *inputReader) Read(buf []byte) (int, error) {
+	fds := []unix.PollFd{{Fd: int32(r.fd), Events: unix.POLLIN}, {Fd: int32(r.stop.Fd()), Events: unix.POLLIN}}
+	for {
+		if _, err := unix.Poll(fds, -1); err != nil {
+			if err == unix.EINTR {
+				continue
+			}
+			return 0, err
+		}
+		if fds[1].Revents != 0 {
+			return 0, io.EOF
+		}
+		if fds[0].Revents != 0 {
+			// hang-ups and errors are reported by the read
+			return r.f.Read(buf)
+		}
+	}
+}
+
+func (r *inputReader) cancel() {
+	r.once.Do(func() {
+		r.stopWrite.Close()
+	})
+}
+
+// Release the reader once reads are done.
+func (r *inputReader) close() {
+	r.cancel()
+	r.stop.Close()
+}
@@ -0,0 +1,223 @@
+package ssh
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"golang.org/x/term"
+)
+
+// Local terminal for Session.Interactive. Unset fields default to os.Stdin,
+// os.Stdout and os.Stderr, $TERM and '~'.
+type InteractiveOption struct {
+	// Local terminal; it is put into raw mode while the session runs.
+	In *os.File
+
+	Out io.Writer
+	Err io.Writer
+
+	// Terminal type for the pty request.
+	Term string
+
+	// Escape character recognized at the start of a line, as in OpenSSH.
+	// NoEscape disables escape sequences.
+	EscapeChar byte
+	NoEscape   bool
+}
+
+// The user closed the session with the "~." escape sequence.
+var ErrEscapeClosed = errors.New("session closed by escape sequence")
+
+const escapeHelp = "Supported escape sequences:\r\n" +
+	" ~.   - terminate session\r\n" +
+	" ~^Z  - suspend\r\n" +
+	" ~?   - this message\r\n" +
+	" ~~   - send the escape character by typing it twice\r\n" +
+	"(Note that escapes are only recognized immediately after newline.)\r\n"
+
+// Run cmd, or the default shell if cmd is empty, on a pty connected to the
+// local terminal until it exits. The local terminal is in raw mode meanwhile,
+// and resizes are forwarded as window-change requests. In is only read while
+// the session runs; except on Unix, one read pending at its end is lost.
+func (s *Session) Interactive(cmd string, option *InteractiveOption) error {
+	o := InteractiveOption{}
+	if option != nil {
+		o = *option
+	}
+	if o.In == nil {
+		o.In = os.Stdin
+	}
+	if o.Out == nil {
+		o.Out = os.Stdout
+	}
+	if o.Err == nil {
+		o.Err = os.Stderr
+	}
+	if len(o.Term) == 0 {
+		o.Term = os.Getenv("TERM")
+	}
+	if o.EscapeChar == 0 {
+		o.EscapeChar = '~'
+	}
+
+	fd := int(o.In.Fd())
+	tty := term.IsTerminal(fd)
+	if tty {
+		rows, cols, err := TerminalSize(fd)
+		if err != nil {
+			return err
+		}
+		modes, err := TerminalModesFromFd(fd)
+		if err != nil {
+			return err
+		}
+		if err := s.RequestPty(o.Term, rows, cols, modes); err != nil {
+			return err
+		}
+	}
+
+	stdin, err := s.StdinPipe()
+	if err != nil {
+		return err
+	}
+	s.Stdout, s.Stderr = o.Out, o.Err
+
+	if len(cmd) > 0 {
+		err = s.Start(cmd)
+	} else {
+		err = s.Shell()
+	}
+	if err != nil {
+		return err
+	}
+
+	var saved *term.State
+	if tty {
+		if saved, err = term.MakeRaw(fd); err != nil {
+			s.Close()
+			return err
+		}
+		defer term.Restore(fd, saved)
+
+		stop := notifyResize(func() {
+			if rows, cols, err := TerminalSize(fd); err == nil {
+				s.WindowChange(rows, cols)
+			}
+		})
+		defer stop()
+	}
+
+	in, err := newInputReader(o.In)
+	if err != nil {
+		s.Close()
+		return err
+	}
+	escaped, copied := make(chan int), make(chan int)
+	go func() {
+		s.copyStdin(stdin, in, &o, saved, escaped)
+		close(copied)
+	}()
+
+	err = s.Wait()
+	// stop the copy so the caller gets the next input
+	in.cancel()
+	if inputInterruptible {
+		<-copied
+	}
+
+	select {
+	case <-escaped:
+		return ErrEscapeClosed
+	default:
+	}
+	return err
+}
+
+// Copy the local terminal to the remote stdin, handling escape sequences.
+// escaped is closed when the user closes the session with "~.". saved is
+// the terminal state before raw mode, nil if In is not a terminal.
+func (s *Session) copyStdin(stdin io.WriteCloser, r *inputReader, o *InteractiveOption, saved *term.State, escaped chan int) {
+	defer stdin.Close()
+	defer r.close()
+
+	in := make([]byte, 1024)
+	out := make([]byte, 0, 2048)
+	lineStart, escape := true, false
+	for {
+		n, err := r.Read(in)
+		out = out[:0]
+		for _, b := range in[:n] {
+			if o.NoEscape {
+				out = append(out, b)
+				continue
+			}
+
+			if escape {
+				escape = false
+				switch b {
+				case '.':
+					stdin.Write(out)
+					io.WriteString(o.Err, string(o.EscapeChar)+".\r\n")
+					close(escaped)
+					s.Close()
+					return
+
+				case 0x1a: // ^Z
+					stdin.Write(out)
+					out = out[:0]
+					io.WriteString(o.Err, string(o.EscapeChar)+"^Z [suspend]\r\n")
+					s.suspend(int(o.In.Fd()), saved)
+
+				case '?':
+					io.WriteString(o.Err, string(o.EscapeChar)+"?\r\n"+escapeHelp)
+
+				case o.EscapeChar:
+					out = append(out, b)
+
+				default:
+					out = append(out, o.EscapeChar, b)
+				}
+				lineStart = (b == '\r') || (b == '\n')
+				continue
+			}
+
+			if lineStart && (b == o.EscapeChar) {
+				escape = true
+				continue
+			}
+			out = append(out, b)
+			lineStart = (b == '\r') || (b == '\n')
+		}
+
+		if len(out) > 0 {
+			if _, err := stdin.Write(out); err != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Stop the local process like OpenSSH does for "~^Z", with the terminal
+// restored meanwhile, and resend the window size after resuming.
+func (s *Session) suspend(fd int, saved *term.State) {
+	if saved == nil {
+		suspendProcess()
+		return
+	}
+
+	raw, err := term.GetState(fd)
+	if err != nil {
+		return
+	}
+	term.Restore(fd, saved)
+	suspendProcess()
+	term.Restore(fd, raw)
+
+	if rows, cols, err := TerminalSize(fd); err == nil {
+		s.WindowChange(rows, cols)
+	}
+}
@@ -0,0 +1,202 @@
+//go:build linux
+
+package ssh
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	xssh "golang.org/x/crypto/ssh"
+	"golang.org/x/sys/unix"
+	"golang.org/x/term"
+)
+
+// Open a pseudo-terminal pair, the terminal side sized rows x cols.
+func openPty(t *testing.T, rows, cols uint16) (master, tty *os.File) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR, 0)
+	if err != nil {
+		t.Skip(err)
+	}
+	t.Cleanup(func() { master.Close() })
+	if err := unix.IoctlSetPointerInt(int(master.Fd()), unix.TIOCSPTLCK, 0); err != nil {
+		t.Fatal(err)
+	}
+	n, err := unix.IoctlGetInt(int(master.Fd()), unix.TIOCGPTN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tty, err = os.OpenFile("/dev/pts/"+strconv.Itoa(n), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tty.Close() })
+	unix.IoctlSetWinsize(int(tty.Fd()), unix.TIOCSWINSZ, &unix.Winsize{Row: rows, Col: cols})
+	return
+}
+
+func (b *lockedBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.String()
+}
+
+// Shell sessions that record their requests and input, and exit after
+// reading "bye".
+func interactiveServer(requests chan<- string, input *lockedBuffer) serverHandler {
+	return func(sc *xssh.ServerConn, chans <-chan xssh.NewChannel, reqs <-chan *xssh.Request) {
+		go xssh.DiscardRequests(reqs)
+		for nc := range chans {
+			ch, creqs, _ := nc.Accept()
+			go func() {
+				for r := range creqs {
+					requests <- r.Type
+					if r.WantReply {
+						r.Reply(true, nil)
+					}
+					if r.Type != "shell" {
+						continue
+					}
+					go func() {
+						b := make([]byte, 256)
+						for {
+							n, err := ch.Read(b)
+							input.Write(b[:n])
+							if err != nil {
+								return
+							}
+							if bytes.HasSuffix([]byte(input.String()), []byte("bye")) {
+								ch.SendRequest("exit-status", false, []byte{0, 0, 0, 0})
+								ch.Close()
+								return
+							}
+						}
+					}()
+				}
+			}()
+		}
+	}
+}
+
+// Wait for the pty and shell requests, then for raw mode.
+func waitStarted(requests chan string) {
+	for len(requests) < 2 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+}
+
+func TestInteractive(t *testing.T) {
+	requests := make(chan string, 20)
+	var input lockedBuffer
+	c := dialTest(t, interactiveServer(requests, &input))
+	defer c.Close()
+
+	master, tty := openPty(t, 24, 80)
+	before, _ := term.GetState(int(tty.Fd()))
+
+	s, _ := c.NewSession()
+	var out, errs lockedBuffer
+	done := make(chan error)
+	go func() {
+		done <- s.Interactive("", &InteractiveOption{In: tty, Out: &out, Err: &errs, Term: "xterm"})
+	}()
+	waitStarted(requests)
+
+	unix.IoctlSetWinsize(int(tty.Fd()), unix.TIOCSWINSZ, &unix.Winsize{Row: 30, Col: 100})
+	unix.Kill(unix.Getpid(), unix.SIGWINCH)
+	time.Sleep(100 * time.Millisecond)
+
+	// "~?" ends the line start, "~\r" starts a new one
+	master.Write([]byte("hello\r~~x\r~?~x\ra~b\r~\r~."))
+	select {
+	case err := <-done:
+		if err != ErrEscapeClosed {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("~. did not close the session")
+	}
+	if got := input.String(); got != "hello\r~x\r~x\ra~b\r~\r" {
+		t.Fatalf("%q", got)
+	}
+	if !bytes.Contains([]byte(errs.String()), []byte("Supported escape")) {
+		t.Fatal(errs.String())
+	}
+
+	var types []string
+	for len(requests) > 0 {
+		types = append(types, <-requests)
+	}
+	if (len(types) != 3) || (types[0] != "pty-req") || (types[1] != "shell") || (types[2] != "window-change") {
+		t.Fatal(types)
+	}
+	if after, _ := term.GetState(int(tty.Fd())); *after != *before {
+		t.Fatal("terminal not restored")
+	}
+}
+
+func TestInteractiveReleasesInput(t *testing.T) {
+	requests := make(chan string, 20)
+	var input lockedBuffer
+	c := dialTest(t, interactiveServer(requests, &input))
+	defer c.Close()
+
+	master, tty := openPty(t, 24, 80)
+	s, _ := c.NewSession()
+	done := make(chan error)
+	go func() {
+		done <- s.Interactive("", &InteractiveOption{In: tty, Out: io.Discard, Err: io.Discard})
+	}()
+	waitStarted(requests)
+	master.Write([]byte("bye"))
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("session did not end")
+	}
+
+	// input after the session is the caller's again
+	master.Write([]byte("next\n"))
+	b := make([]byte, 16)
+	n, err := tty.Read(b)
+	if (err != nil) || (string(b[:n]) != "next\n") {
+		t.Fatalf("%q %v", b[:n], err)
+	}
+	if got := input.String(); got != "bye" {
+		t.Fatalf("%q", got)
+	}
+}
+
+// Descriptors past FD_SETSIZE are watched as well.
+func TestInputReaderHighFd(t *testing.T) {
+	master, tty := openPty(t, 24, 80)
+	const high = 2000
+	if err := unix.Dup3(int(tty.Fd()), high, unix.O_CLOEXEC); err != nil {
+		t.Skip(err)
+	}
+	f := os.NewFile(high, "tty")
+	defer f.Close()
+	term.MakeRaw(high)
+
+	r, err := newInputReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.close()
+	master.Write([]byte("x"))
+	b := make([]byte, 4)
+	if n, err := r.Read(b); (string(b[:n]) != "x") || (err != nil) {
+		t.Fatalf("%q %v", b[:n], err)
+	}
+	r.cancel()
+	if _, err := r.Read(b); err != io.EOF {
+		t.Fatal(err)
+	}
+}
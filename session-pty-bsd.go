@@ -0,0 +1,52 @@
+//go:build darwin || freebsd || netbsd || openbsd || dragonfly
+
+package ssh
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+var termiosChars = []termiosChar{
+	{VINTR, unix.VINTR}, {VQUIT, unix.VQUIT}, {VERASE, unix.VERASE},
+	{VKILL, unix.VKILL}, {VEOF, unix.VEOF}, {VEOL, unix.VEOL},
+	{VEOL2, unix.VEOL2}, {VSTART, unix.VSTART}, {VSTOP, unix.VSTOP},
+	{VSUSP, unix.VSUSP}, {VDSUSP, unix.VDSUSP}, {VREPRINT, unix.VREPRINT},
+	{VWERASE, unix.VWERASE}, {VLNEXT, unix.VLNEXT}, {VSTATUS, unix.VSTATUS},
+	{VDISCARD, unix.VDISCARD},
+}
+
+var termiosFlags = []termiosFlag{
+	{IGNPAR, termiosIflag, unix.IGNPAR}, {PARMRK, termiosIflag, unix.PARMRK},
+	{INPCK, termiosIflag, unix.INPCK}, {ISTRIP, termiosIflag, unix.ISTRIP},
+	{INLCR, termiosIflag, unix.INLCR}, {IGNCR, termiosIflag, unix.IGNCR},
+	{ICRNL, termiosIflag, unix.ICRNL}, {IXON, termiosIflag, unix.IXON},
+	{IXANY, termiosIflag, unix.IXANY}, {IXOFF, termiosIflag, unix.IXOFF},
+	{IMAXBEL, termiosIflag, unix.IMAXBEL},
+
+	{ISIG, termiosLflag, unix.ISIG}, {ICANON, termiosLflag, unix.ICANON},
+	{ECHO, termiosLflag, unix.ECHO}, {ECHOE, termiosLflag, unix.ECHOE},
+	{ECHOK, termiosLflag, unix.ECHOK}, {ECHONL, termiosLflag, unix.ECHONL},
+	{NOFLSH, termiosLflag, unix.NOFLSH}, {TOSTOP, termiosLflag, unix.TOSTOP},
+	{IEXTEN, termiosLflag, unix.IEXTEN}, {ECHOCTL, termiosLflag, unix.ECHOCTL},
+	{ECHOKE, termiosLflag, unix.ECHOKE}, {PENDIN, termiosLflag, unix.PENDIN},
+
+	{OPOST, termiosOflag, unix.OPOST}, {ONLCR, termiosOflag, unix.ONLCR},
+	{OCRNL, termiosOflag, unix.OCRNL}, {ONOCR, termiosOflag, unix.ONOCR},
+	{ONLRET, termiosOflag, unix.ONLRET},
+
+	{CS7, termiosCsize, unix.CS7}, {CS8, termiosCsize, unix.CS8},
+	{PARENB, termiosCflag, unix.PARENB}, {PARODD, termiosCflag, unix.PARODD},
+}
+
+// The BSDs keep the line speed in baud in c_ispeed and c_ospeed.
+func readTermios(fd int) (*termios, error) {
+	t, err := unix.IoctlGetTermios(fd, unix.TIOCGETA)
+	if err != nil {
+		return nil, err
+	}
+	return &termios{
+		iflag: uint64(t.Iflag), oflag: uint64(t.Oflag), cflag: uint64(t.Cflag), lflag: uint64(t.Lflag),
+		cc:     t.Cc[:],
+		ispeed: uint32(t.Ispeed), ospeed: uint32(t.Ospeed),
+	}, nil
+}
@@ -0,0 +1,66 @@
+//go:build linux
+
+package ssh
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+var termiosChars = []termiosChar{
+	{VINTR, unix.VINTR}, {VQUIT, unix.VQUIT}, {VERASE, unix.VERASE},
+	{VKILL, unix.VKILL}, {VEOF, unix.VEOF}, {VEOL, unix.VEOL},
+	{VEOL2, unix.VEOL2}, {VSTART, unix.VSTART}, {VSTOP, unix.VSTOP},
+	{VSUSP, unix.VSUSP}, {VREPRINT, unix.VREPRINT}, {VWERASE, unix.VWERASE},
+	{VLNEXT, unix.VLNEXT}, {VSWTCH, unix.VSWTC}, {VDISCARD, unix.VDISCARD},
+}
+
+var termiosFlags = []termiosFlag{
+	{IGNPAR, termiosIflag, unix.IGNPAR}, {PARMRK, termiosIflag, unix.PARMRK},
+	{INPCK, termiosIflag, unix.INPCK}, {ISTRIP, termiosIflag, unix.ISTRIP},
+	{INLCR, termiosIflag, unix.INLCR}, {IGNCR, termiosIflag, unix.IGNCR},
+	{ICRNL, termiosIflag, unix.ICRNL}, {IUCLC, termiosIflag, unix.IUCLC},
+	{IXON, termiosIflag, unix.IXON}, {IXANY, termiosIflag, unix.IXANY},
+	{IXOFF, termiosIflag, unix.IXOFF}, {IMAXBEL, termiosIflag, unix.IMAXBEL},
+	{IUTF8, termiosIflag, unix.IUTF8},
+
+	{ISIG, termiosLflag, unix.ISIG}, {ICANON, termiosLflag, unix.ICANON},
+	{XCASE, termiosLflag, unix.XCASE}, {ECHO, termiosLflag, unix.ECHO},
+	{ECHOE, termiosLflag, unix.ECHOE}, {ECHOK, termiosLflag, unix.ECHOK},
+	{ECHONL, termiosLflag, unix.ECHONL}, {NOFLSH, termiosLflag, unix.NOFLSH},
+	{TOSTOP, termiosLflag, unix.TOSTOP}, {IEXTEN, termiosLflag, unix.IEXTEN},
+	{ECHOCTL, termiosLflag, unix.ECHOCTL}, {ECHOKE, termiosLflag, unix.ECHOKE},
+	{PENDIN, termiosLflag, unix.PENDIN},
+
+	{OPOST, termiosOflag, unix.OPOST}, {OLCUC, termiosOflag, unix.OLCUC},
+	{ONLCR, termiosOflag, unix.ONLCR}, {OCRNL, termiosOflag, unix.OCRNL},
+	{ONOCR, termiosOflag, unix.ONOCR}, {ONLRET, termiosOflag, unix.ONLRET},
+
+	{CS7, termiosCsize, unix.CS7}, {CS8, termiosCsize, unix.CS8},
+	{PARENB, termiosCflag, unix.PARENB}, {PARODD, termiosCflag, unix.PARODD},
+}
+
+// Linux keeps the line speed as a Bxxx code in c_cflag.
+var termiosBauds = map[uint32]uint32{
+	unix.B50: 50, unix.B75: 75, unix.B110: 110, unix.B134: 134,
+	unix.B150: 150, unix.B200: 200, unix.B300: 300, unix.B600: 600,
+	unix.B1200: 1200, unix.B1800: 1800, unix.B2400: 2400, unix.B4800: 4800,
+	unix.B9600: 9600, unix.B19200: 19200, unix.B38400: 38400,
+	unix.B57600: 57600, unix.B115200: 115200, unix.B230400: 230400,
+	unix.B460800: 460800, unix.B500000: 500000, unix.B576000: 576000,
+	unix.B921600: 921600, unix.B1000000: 1000000, unix.B1152000: 1152000,
+	unix.B1500000: 1500000, unix.B2000000: 2000000, unix.B2500000: 2500000,
+	unix.B3000000: 3000000, unix.B3500000: 3500000, unix.B4000000: 4000000,
+}
+
+func readTermios(fd int) (*termios, error) {
+	t, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return nil, err
+	}
+	speed := termiosBauds[t.Cflag&unix.CBAUD]
+	return &termios{
+		iflag: uint64(t.Iflag), oflag: uint64(t.Oflag), cflag: uint64(t.Cflag), lflag: uint64(t.Lflag),
+		cc:     t.Cc[:],
+		ispeed: speed, ospeed: speed,
+	}, nil
+}
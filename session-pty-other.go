@@ -0,0 +1,18 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package ssh
+
+import (
+	"errors"
+)
+
+// Map the settings of the terminal fd to pty-req modes; not supported on
+// this platform.
+func TerminalModesFromFd(fd int) (TerminalModes, error) {
+	return nil, errors.New("terminal modes not supported on this platform")
+}
+
+// Size of the terminal fd in characters; not supported on this platform.
+func TerminalSize(fd int) (rows, cols int, err error) {
+	return 0, 0, errors.New("terminal size not supported on this platform")
+}
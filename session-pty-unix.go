@@ -0,0 +1,82 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package ssh
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// Terminal state in a form shared by the platform specific readers.
+type termios struct {
+	iflag, oflag, cflag, lflag uint64
+	cc                         []uint8
+	ispeed, ospeed             uint32
+}
+
+type termiosFlag struct {
+	op    uint8
+	field int
+	mask  uint64
+}
+
+// termiosFlag.field
+const (
+	termiosIflag = iota
+	termiosOflag
+	termiosCflag
+	termiosLflag
+
+	// character size, c_cflag & CSIZE
+	termiosCsize
+)
+
+type termiosChar struct {
+	op    uint8
+	index int
+}
+
+// Map the settings of the terminal fd to pty-req modes, so that the remote
+// pty behaves like the local one.
+func TerminalModesFromFd(fd int) (TerminalModes, error) {
+	t, err := readTermios(fd)
+	if err != nil {
+		return nil, err
+	}
+
+	modes := TerminalModes{}
+	for _, c := range termiosChars {
+		if c.index < len(t.cc) {
+			modes[c.op] = uint32(t.cc[c.index])
+		}
+	}
+
+	fields := [...]uint64{t.iflag, t.oflag, t.cflag, t.lflag, t.cflag & unix.CSIZE}
+	for _, f := range termiosFlags {
+		set := fields[f.field]&f.mask == f.mask
+		if f.field == termiosCsize {
+			set = fields[f.field] == f.mask
+		}
+		if set {
+			modes[f.op] = 1
+		} else {
+			modes[f.op] = 0
+		}
+	}
+
+	if t.ispeed > 0 {
+		modes[TtyOpIspeed] = t.ispeed
+	}
+	if t.ospeed > 0 {
+		modes[TtyOpOspeed] = t.ospeed
+	}
+	return modes, nil
+}
+
+// Size of the terminal fd in characters.
+func TerminalSize(fd int) (rows, cols int, err error) {
+	ws, err := unix.IoctlGetWinsize(fd, unix.TIOCGWINSZ)
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(ws.Row), int(ws.Col), nil
+}
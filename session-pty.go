@@ -0,0 +1,127 @@
+package ssh
+
+import (
+	"errors"
+	"sort"
+)
+
+// Terminal mode opcodes (RFC4254/8, IUTF8 from RFC8160).
+const (
+	TtyOpEnd = 0
+
+	VINTR    = 1
+	VQUIT    = 2
+	VERASE   = 3
+	VKILL    = 4
+	VEOF     = 5
+	VEOL     = 6
+	VEOL2    = 7
+	VSTART   = 8
+	VSTOP    = 9
+	VSUSP    = 10
+	VDSUSP   = 11
+	VREPRINT = 12
+	VWERASE  = 13
+	VLNEXT   = 14
+	VFLUSH   = 15
+	VSWTCH   = 16
+	VSTATUS  = 17
+	VDISCARD = 18
+
+	IGNPAR  = 30
+	PARMRK  = 31
+	INPCK   = 32
+	ISTRIP  = 33
+	INLCR   = 34
+	IGNCR   = 35
+	ICRNL   = 36
+	IUCLC   = 37
+	IXON    = 38
+	IXANY   = 39
+	IXOFF   = 40
+	IMAXBEL = 41
+	IUTF8   = 42
+
+	ISIG    = 50
+	ICANON  = 51
+	XCASE   = 52
+	ECHO    = 53
+	ECHOE   = 54
+	ECHOK   = 55
+	ECHONL  = 56
+	NOFLSH  = 57
+	TOSTOP  = 58
+	IEXTEN  = 59
+	ECHOCTL = 60
+	ECHOKE  = 61
+	PENDIN  = 62
+
+	OPOST  = 70
+	OLCUC  = 71
+	ONLCR  = 72
+	OCRNL  = 73
+	ONOCR  = 74
+	ONLRET = 75
+
+	CS7    = 90
+	CS8    = 91
+	PARENB = 92
+	PARODD = 93
+
+	TtyOpIspeed = 128
+	TtyOpOspeed = 129
+)
+
+// Terminal modes for a pty request, opcode to value. Flags are 0 or 1,
+// control characters are character codes and speeds are in baud.
+type TerminalModes map[uint8]uint32
+
+// Encode the modes as the pty-req "encoded terminal modes" string.
+func (m TerminalModes) Marshal() []byte {
+	ops := make([]int, 0, len(m))
+	for op := range m {
+		if (op != TtyOpEnd) && (op < 160) {
+			ops = append(ops, int(op))
+		}
+	}
+	sort.Ints(ops)
+
+	e := NewEncoder()
+	for _, op := range ops {
+		e.Byte(byte(op)).U32(m[uint8(op)])
+	}
+	return e.Byte(TtyOpEnd).Out()
+}
+
+func ptyRequestData(term string, rows, cols int, modes TerminalModes) []byte {
+	return NewEncoder().U32String(term).
+		U32(uint32(cols)).U32(uint32(rows)).U32(0).U32(0).
+		U32Bytes(modes.Marshal()).
+		Out()
+}
+
+// Request a pseudo-terminal of rows by cols characters before Start or
+// Shell. An empty term uses "xterm"; see TerminalModesFromFd for modes.
+func (s *Session) RequestPty(term string, rows, cols int, modes TerminalModes) error {
+	if s.started {
+		return errors.New("RequestPty after process started")
+	}
+	if len(term) == 0 {
+		term = "xterm"
+	}
+
+	ok, err := s.ch.SendRequest("pty-req", true, ptyRequestData(term, rows, cols, modes))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("pty-req request refused")
+	}
+	return nil
+}
+
+// Tell the server that the terminal was resized to rows by cols characters.
+func (s *Session) WindowChange(rows, cols int) error {
+	_, err := s.ch.SendRequest("window-change", false, NewEncoder().U32(uint32(cols)).U32(uint32(rows)).U32(0).U32(0).Out())
+	return err
+}
@@ -0,0 +1,299 @@
+package ssh
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const x11AuthProtocol = "MIT-MAGIC-COOKIE-1"
+
+// Local X server a forwarded x11 channel connects to.
+type x11Display struct {
+	network, address string
+
+	// Display number as written in Xauthority entries, and the screen
+	// remote clients use by default.
+	number string
+	screen uint32
+
+	// Real authorization, empty if Xauthority has none for the display.
+	authName string
+	authData []byte
+
+	// Forward only the first connection.
+	single bool
+}
+
+// Fake cookies sent in x11-req mapped to the displays they stand for. The
+// lookup happens in the goroutine of each x11 channel.
+type x11Cookies struct {
+	mutex    sync.Mutex
+	displays map[string]*x11Display
+}
+
+func (x *x11Cookies) add(cookie string, d *x11Display) {
+	x.mutex.Lock()
+	if x.displays == nil {
+		x.displays = map[string]*x11Display{}
+	}
+	x.displays[cookie] = d
+	x.mutex.Unlock()
+}
+
+func (x *x11Cookies) remove(cookie string) {
+	x.mutex.Lock()
+	delete(x.displays, cookie)
+	x.mutex.Unlock()
+}
+
+// The display of cookie, nil if unknown. The cookie of a single connection
+// display is removed with the same lock, so it is taken once only.
+func (x *x11Cookies) take(cookie string) *x11Display {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	d := x.displays[cookie]
+	if (d != nil) && d.single {
+		delete(x.displays, cookie)
+	}
+	return d
+}
+
+// Request X11 forwarding before Start or Shell (RFC4254/6.3). Remote X
+// clients authenticate with a generated fake MIT-MAGIC-COOKIE-1, which is
+// checked and replaced by the real cookie from Xauthority before the
+// connection reaches the X server named by display, or $DISPLAY if empty.
+// With single only one connection is forwarded.
+func (s *Session) RequestX11(display string, single bool) error {
+	if s.started {
+		return errors.New("RequestX11 after process started")
+	}
+	if len(display) == 0 {
+		display = os.Getenv("DISPLAY")
+	}
+	d, err := parseDisplay(display)
+	if err != nil {
+		return err
+	}
+	d.authName, d.authData = readXauthority(xauthorityFile(), d)
+	d.single = single
+
+	cookie := hex.EncodeToString(rand(16))
+	c := s.ch.client
+	c.x11.add(cookie, d)
+	c.do(func() {
+		c.handleChannelType("x11", c.acceptX11)
+	})
+
+	data := NewEncoder()
+	if single {
+		data.Byte(1)
+	} else {
+		data.Byte(0)
+	}
+	data.U32String(x11AuthProtocol).U32String(cookie).U32(d.screen)
+
+	ok, err := s.ch.SendRequest("x11-req", true, data.Out())
+	if (err == nil) && !ok {
+		err = errors.New("x11-req request refused")
+	}
+	if err != nil {
+		c.x11.remove(cookie)
+		return err
+	}
+
+	s.x11Cookie = cookie
+	return nil
+}
+
+// Parse an X display name: ":0", "unix:0", "host:0.1" or a socket path
+// ending in ":0" as used by XQuartz, each optionally followed by a screen.
+func parseDisplay(display string) (*x11Display, error) {
+	i := strings.LastIndex(display, ":")
+	if i < 0 {
+		return nil, errors.New("invalid DISPLAY " + strconv.Quote(display))
+	}
+	host, number, screen := display[:i], display[i+1:], "0"
+	if j := strings.Index(number, "."); j >= 0 {
+		number, screen = number[:j], number[j+1:]
+	}
+	n, err := strconv.Atoi(number)
+	if err != nil {
+		return nil, errors.New("invalid DISPLAY " + strconv.Quote(display))
+	}
+	s, err := strconv.ParseUint(screen, 10, 32)
+	if err != nil {
+		return nil, errors.New("invalid DISPLAY " + strconv.Quote(display))
+	}
+
+	d := &x11Display{number: number, screen: uint32(s)}
+	switch {
+	case strings.HasPrefix(display, "/"):
+		d.network, d.address = "unix", host+":"+number
+	case (len(host) == 0) || (host == "unix") || (host == "unix/"):
+		d.network, d.address = "unix", "/tmp/.X11-unix/X"+number
+	default:
+		d.network, d.address = "tcp", net.JoinHostPort(strings.TrimPrefix(host, "tcp/"), strconv.Itoa(6000+n))
+	}
+	return d, nil
+}
+
+func xauthorityFile() string {
+	if f := os.Getenv("XAUTHORITY"); len(f) > 0 {
+		return f
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".Xauthority")
+}
+
+// Xauthority address families.
+const (
+	xauthFamilyInternet  = 0
+	xauthFamilyInternet6 = 6
+	xauthFamilyLocal     = 256
+	xauthFamilyWild      = 65535
+)
+
+// Find the MIT-MAGIC-COOKIE-1 for d in the Xauthority file.
+func readXauthority(file string, d *x11Display) (name string, data []byte) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return
+	}
+
+	hostname, _ := os.Hostname()
+	var ips []net.IP
+	if d.network == "tcp" {
+		host, _, _ := net.SplitHostPort(d.address)
+		ips, _ = net.LookupIP(host)
+	}
+
+	field := func() []byte {
+		if len(b) < 2 {
+			b = nil
+			return nil
+		}
+		n := int(binary.BigEndian.Uint16(b))
+		if len(b) < 2+n {
+			b = nil
+			return nil
+		}
+		f := b[2 : 2+n]
+		b = b[2+n:]
+		return f
+	}
+
+	for len(b) >= 2 {
+		family := binary.BigEndian.Uint16(b)
+		b = b[2:]
+		address, number, authName, authData := field(), field(), field(), field()
+		if (string(number) != d.number) || (string(authName) != x11AuthProtocol) {
+			continue
+		}
+
+		match := false
+		switch family {
+		case xauthFamilyWild:
+			match = true
+		case xauthFamilyLocal:
+			match = (d.network == "unix") && (string(address) == hostname)
+		case xauthFamilyInternet, xauthFamilyInternet6:
+			for _, ip := range ips {
+				match = match || ip.Equal(net.IP(address))
+			}
+		}
+		if match {
+			return x11AuthProtocol, authData
+		}
+	}
+	return
+}
+
+func (c *Client) acceptX11(data []byte) (*Channel, error) {
+	var originHost string
+	var originPort uint32
+	NewDecoder(data).U32String(&originHost).U32(&originPort)
+
+	conn := newTunnelConn(c)
+	conn.laddr = &net.UnixAddr{Net: "unix"}
+	conn.raddr = tcpAddr(originHost, int(originPort))
+	go serveX11(&c.x11, conn)
+	return conn.Channel, nil
+}
+
+// Check the fake cookie in the X connection setup, then connect to the
+// display and relay the setup with the real cookie and the rest both ways.
+func serveX11(cookies *x11Cookies, conn *TunnelConn) {
+	defer conn.Close()
+
+	// xConnClientPrefix: byte-order, pad, major, minor, name length, data
+	// length, pad; then the padded name and data
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if header[0] == 'B' {
+		order = binary.BigEndian
+	}
+	nameLen, dataLen := int(order.Uint16(header[6:])), int(order.Uint16(header[8:]))
+	auth := make([]byte, pad4(nameLen)+pad4(dataLen))
+	if _, err := io.ReadFull(conn, auth); err != nil {
+		return
+	}
+	name, cookie := auth[:nameLen], auth[pad4(nameLen):pad4(nameLen)+dataLen]
+
+	var d *x11Display
+	if string(name) == x11AuthProtocol {
+		d = cookies.take(hex.EncodeToString(cookie))
+	}
+	if d == nil {
+		Log(5, "x11: refusing connection from %v with wrong authorization", conn.RemoteAddr())
+		return
+	}
+
+	x, err := net.Dial(d.network, d.address)
+	if err != nil {
+		Log(5, "x11: %v", err)
+		return
+	}
+	defer x.Close()
+
+	setup := bytes.NewBuffer(nil)
+	setup.Write(header[:6])
+	binary.Write(setup, order, uint16(len(d.authName)))
+	binary.Write(setup, order, uint16(len(d.authData)))
+	setup.Write(header[10:12])
+	setup.WriteString(d.authName)
+	setup.Write(make([]byte, pad4(len(d.authName))-len(d.authName)))
+	setup.Write(d.authData)
+	setup.Write(make([]byte, pad4(len(d.authData))-len(d.authData)))
+	if _, err := x.Write(setup.Bytes()); err != nil {
+		return
+	}
+
+	done := make(chan int, 1)
+	go func() {
+		io.Copy(conn, x)
+		conn.CloseWrite()
+		done <- 0
+	}()
+	io.Copy(x, conn)
+	if cw, ok := x.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	}
+	<-done
+}
+
+func pad4(n int) int {
+	return (n + 3) &^ 3
+}
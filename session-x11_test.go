@@ -0,0 +1,176 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package ssh
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	xssh "golang.org/x/crypto/ssh"
+)
+
+// An Xauthority file entry.
+func xauthEntry(family uint16, addr, number, name string, data []byte) []byte {
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, family)
+	for _, f := range [][]byte{[]byte(addr), []byte(number), []byte(name), data} {
+		binary.Write(&b, binary.BigEndian, uint16(len(f)))
+		b.Write(f)
+	}
+	return b.Bytes()
+}
+
+// An X connection setup request, little endian, with the given
+// authorization.
+func xsetup(name string, data []byte) []byte {
+	var b bytes.Buffer
+	b.Write([]byte{'l', 0, 11, 0, 0, 0})
+	binary.Write(&b, binary.LittleEndian, uint16(len(name)))
+	binary.Write(&b, binary.LittleEndian, uint16(len(data)))
+	b.Write([]byte{0, 0})
+	b.WriteString(name)
+	b.Write(make([]byte, pad4(len(name))-len(name)))
+	b.Write(data)
+	b.Write(make([]byte, pad4(len(data))-len(data)))
+	return b.Bytes()
+}
+
+// The fake X server checks that the session's fake cookie was replaced by
+// the real one; a wrong cookie and a second connection with single are
+// refused.
+func TestX11(t *testing.T) {
+	dir := t.TempDir()
+	real := []byte("0123456789abcdef")
+	host, _ := os.Hostname()
+	xauth := filepath.Join(dir, "Xauthority")
+	os.WriteFile(xauth, append(xauthEntry(256, host, "7", "MIT-MAGIC-COOKIE-1", []byte("other-display!!!")), xauthEntry(256, host, "42", "MIT-MAGIC-COOKIE-1", real)...), 0600)
+	t.Setenv("XAUTHORITY", xauth)
+
+	display := filepath.Join(dir, "xserver") + ":42"
+	xl, err := net.Listen("unix", display)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer xl.Close()
+	gotSetup := make(chan []byte, 4)
+	go func() {
+		for {
+			c, err := xl.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				b := make([]byte, len(xsetup("MIT-MAGIC-COOKIE-1", real)))
+				io.ReadFull(c, b)
+				gotSetup <- b
+				c.Write([]byte("X-OK"))
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+
+	results := make(chan string, 4)
+	screens := make(chan uint32, 1)
+	h := func(sc *xssh.ServerConn, chans <-chan xssh.NewChannel, reqs <-chan *xssh.Request) {
+		go xssh.DiscardRequests(reqs)
+		for nc := range chans {
+			ch, creqs, _ := nc.Accept()
+			go func() {
+				for r := range creqs {
+					if r.Type == "x11-req" {
+						var req struct {
+							Single        bool
+							Proto, Cookie string
+							Screen        uint32
+						}
+						xssh.Unmarshal(r.Payload, &req)
+						screens <- req.Screen
+						r.Reply(true, nil)
+						fake, _ := hex.DecodeString(req.Cookie)
+						go func() {
+							for _, cookie := range [][]byte{fake, []byte("bad-cookie-12345"), fake} {
+								x, xr, err := sc.OpenChannel("x11", xssh.Marshal(struct {
+									H string
+									P uint32
+								}{"127.0.0.1", 5555}))
+								if err != nil {
+									results <- "open " + err.Error()
+									continue
+								}
+								go xssh.DiscardRequests(xr)
+								x.Write(xsetup(req.Proto, cookie))
+								x.Write([]byte("ping"))
+								done := make(chan []byte)
+								go func() { b, _ := io.ReadAll(x); done <- b }()
+								select {
+								case b := <-done:
+									results <- string(b)
+								case <-time.After(500 * time.Millisecond):
+									x.Close()
+									results <- "timeout"
+								}
+							}
+						}()
+					} else if r.WantReply {
+						r.Reply(false, nil)
+					}
+				}
+				ch.Close()
+			}()
+		}
+	}
+	c := dialTest(t, h)
+	defer c.Close()
+	s, _ := c.NewSession()
+	defer s.Close()
+	if err := s.RequestX11(display+".1", true); err != nil {
+		t.Fatal(err)
+	}
+	if screen := <-screens; screen != 1 {
+		t.Fatal("screen", screen)
+	}
+	r1 := <-results
+	if r1 != "timeout" && r1[:4] != "X-OK" {
+		t.Fatal(r1)
+	}
+	if b := <-gotSetup; !bytes.Equal(b, xsetup("MIT-MAGIC-COOKIE-1", real)) {
+		t.Fatalf("%q", b)
+	}
+	r2 := <-results
+	r3 := <-results
+	t.Log(r1, "|", r2, "|", r3)
+	if r2 != "" || r3 != "" {
+		t.Fatal("bad cookie or second single connection accepted")
+	}
+}
+
+func TestParseDisplay(t *testing.T) {
+	for _, c := range []struct {
+		in, net, addr, num string
+		screen             uint32
+	}{
+		{":0", "unix", "/tmp/.X11-unix/X0", "0", 0},
+		{"unix:1.0", "unix", "/tmp/.X11-unix/X1", "1", 0},
+		{"localhost:10.2", "tcp", "localhost:6010", "10", 2},
+		{"/private/tmp/com.apple.launchd.x/org.xquartz:0", "unix", "/private/tmp/com.apple.launchd.x/org.xquartz:0", "0", 0},
+		{"/private/tmp/com.apple.launchd.x/org.xquartz:0.1", "unix", "/private/tmp/com.apple.launchd.x/org.xquartz:0", "0", 1},
+	} {
+		d, err := parseDisplay(c.in)
+		if err != nil || d.network != c.net || d.address != c.addr || d.number != c.num || d.screen != c.screen {
+			t.Fatal(c, d, err)
+		}
+	}
+	for _, in := range []string{"localhost", ":x", ":0.x"} {
+		if _, err := parseDisplay(in); err == nil {
+			t.Fatal(in)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// Remote command or shell running in a "session" channel (RFC4254/6).
+type Session struct {
+	// Remote stdin, stdout and stderr. Unset streams read nothing and
+	// discard their output.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	ch             *Channel
+	stdout, stderr *chanBuffer
+
+	// Closed with the channel.
+	closed chan int
+
+	exit *ExitError
+
+	// Fake cookie of RequestX11, forgotten with the channel.
+	x11Cookie string
+
+	started                           bool
+	stdinPipe, stdoutPipe, stderrPipe bool
+	copies                            chan error
+	copying                           int
+}
+
+// A remote command exited with a non-zero status or was killed by a signal.
+type ExitError struct {
+	Status int
+
+	// Signal name without the "SIG" prefix, empty for a normal exit.
+	Signal     string
+	CoreDumped bool
+	Message    string
+	Lang       string
+}
+
+func (e *ExitError) Error() string {
+	if len(e.Signal) > 0 {
+		return fmt.Sprintf("remote command killed by signal %v %v", e.Signal, e.Message)
+	}
+	return fmt.Sprintf("remote command exited with status %v", e.Status)
+}
+
+// Open a session channel.
+func (c *Client) NewSession() (*Session, error) {
+	return c.NewSessionContext(context.Background())
+}
+
+// Like NewSession, giving up when ctx is done before the server answers.
+func (c *Client) NewSessionContext(ctx context.Context) (*Session, error) {
+	s := &Session{closed: make(chan int)}
+
+	ch := &Channel{bufferedReads: true}
+	s.stdout = newChanBuffer(ch.consume)
+	s.stderr = newChanBuffer(ch.consume)
+	ch.ChannelSink = &ChannelSink{
+		OnChannelData: func(ch *Channel, data []byte) {
+			s.stdout.write(data)
+		},
+
+		OnChannelExtendedData: func(ch *Channel, data []byte, dataType uint32) {
+			// SSH_EXTENDED_DATA_STDERR
+			if dataType == 1 {
+				s.stderr.write(data)
+			} else {
+				ch.discard(len(data))
+			}
+		},
+
+		OnChannelEOF: func(ch *Channel) {
+			s.stdout.close()
+			s.stderr.close()
+		},
+
+		OnChannelClose: func(ch *Channel) {
+			if len(s.x11Cookie) > 0 {
+				ch.client.x11.remove(s.x11Cookie)
+			}
+			s.stdout.close()
+			s.stderr.close()
+			close(s.closed)
+		},
+
+		OnChannelRequest: s.handleRequest,
+	}
+
+	if err := c.openChannel(ctx, "session", nil, ch); err != nil {
+		return nil, err
+	}
+	s.ch = ch
+	return s, nil
+}
+
+func (s *Session) handleRequest(ch *Channel, name string, wantReply bool, data []byte) bool {
+	switch name {
+	case "exit-status":
+		var status uint32
+		NewDecoder(data).U32(&status).End()
+		s.exit = &ExitError{Status: int(status)}
+		return true
+
+	case "exit-signal":
+		var core byte
+		e := &ExitError{Status: -1}
+		NewDecoder(data).U32String(&e.Signal).Byte(&core).U32String(&e.Message).U32String(&e.Lang).End()
+		e.CoreDumped = core != 0
+		s.exit = e
+		return true
+	}
+	return false
+}
+
+// Start cmd on the remote host; see Wait.
+func (s *Session) Start(cmd string) error {
+	return s.start("exec", NewEncoder().U32String(cmd).Out())
+}
+
+// Start the user's default shell on the remote host; see Wait.
+func (s *Session) Shell() error {
+	return s.start("shell", nil)
+}
+
+func (s *Session) start(name string, data []byte) error {
+	if s.started {
+		return errors.New("session already started")
+	}
+
+	ok, err := s.ch.SendRequest(name, true, data)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New(name + " request refused")
+	}
+	s.started = true
+
+	s.copies = make(chan error, 2)
+	if !s.stdinPipe {
+		go func() {
+			if s.Stdin != nil {
+				io.Copy(s.ch, s.Stdin)
+			}
+			s.ch.CloseWrite()
+		}()
+	}
+	if !s.stdoutPipe {
+		s.copy(s.Stdout, s.stdout)
+	}
+	if !s.stderrPipe {
+		s.copy(s.Stderr, s.stderr)
+	}
+	return nil
+}
+
+func (s *Session) copy(w io.Writer, r io.Reader) {
+	if w == nil {
+		w = ioutil.Discard
+	}
+	s.copying++
+	go func() {
+		_, err := io.Copy(w, r)
+		s.copies <- err
+	}()
+}
+
+// Wait until the remote command exits and its output is copied. An
+// *ExitError reports a failed command.
+func (s *Session) Wait() error {
+	if !s.started {
+		return errors.New("session not started")
+	}
+
+	<-s.closed
+	var err error
+	for ; s.copying > 0; s.copying-- {
+		if e := <-s.copies; (e != nil) && (err == nil) {
+			err = e
+		}
+	}
+
+	if s.exit == nil {
+		return errors.New("remote command exited without exit status or exit signal")
+	}
+	if (s.exit.Status != 0) || (len(s.exit.Signal) > 0) {
+		return s.exit
+	}
+	return err
+}
+
+// Start cmd and wait for it.
+func (s *Session) Run(cmd string) error {
+	if err := s.Start(cmd); err != nil {
+		return err
+	}
+	return s.Wait()
+}
+
+// Run cmd and return its stdout.
+func (s *Session) Output(cmd string) ([]byte, error) {
+	if s.Stdout != nil {
+		return nil, errors.New("Stdout already set")
+	}
+
+	var b bytes.Buffer
+	s.Stdout = &b
+	err := s.Run(cmd)
+	return b.Bytes(), err
+}
+
+// Run cmd and return its stdout and stderr interleaved.
+func (s *Session) CombinedOutput(cmd string) ([]byte, error) {
+	if (s.Stdout != nil) || (s.Stderr != nil) {
+		return nil, errors.New("Stdout or Stderr already set")
+	}
+
+	var b lockedBuffer
+	s.Stdout, s.Stderr = &b, &b
+	err := s.Run(cmd)
+	return b.buffer.Bytes(), err
+}
+
+// Buffer shared by the stdout and stderr copies. The buffer is a named field
+// so that io.Copy cannot bypass the lock through bytes.Buffer.ReadFrom.
+type lockedBuffer struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(data []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.Write(data)
+}
+
+// Return a pipe to the remote stdin; closing it sends EOF.
+func (s *Session) StdinPipe() (io.WriteCloser, error) {
+	if s.Stdin != nil {
+		return nil, errors.New("Stdin already set")
+	}
+	if s.started {
+		return nil, errors.New("StdinPipe after process started")
+	}
+	s.stdinPipe = true
+	return sessionStdin{s.ch}, nil
+}
+
+type sessionStdin struct {
+	*Channel
+}
+
+func (w sessionStdin) Close() error {
+	return w.CloseWrite()
+}
+
+// Return a pipe to the remote stdout. It must be drained, or the remote
+// command stalls once the channel window is used up.
+func (s *Session) StdoutPipe() (io.Reader, error) {
+	if s.Stdout != nil {
+		return nil, errors.New("Stdout already set")
+	}
+	if s.started {
+		return nil, errors.New("StdoutPipe after process started")
+	}
+	s.stdoutPipe = true
+	return s.stdout, nil
+}
+
+// Return a pipe to the remote stderr, see StdoutPipe.
+func (s *Session) StderrPipe() (io.Reader, error) {
+	if s.Stderr != nil {
+		return nil, errors.New("Stderr already set")
+	}
+	if s.started {
+		return nil, errors.New("StderrPipe after process started")
+	}
+	s.stderrPipe = true
+	return s.stderr, nil
+}
+
+// Close the session channel.
+func (s *Session) Close() error {
+	return s.ch.Close()
+}
@@ -0,0 +1,179 @@
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	xssh "golang.org/x/crypto/ssh"
+)
+
+// Sessions running a few fake commands:
+//
+//	exit N     exits with status N
+//	kill       is killed by SIGKILL
+//	echo TEXT  writes TEXT to stdout
+//	mixed      writes to stdout and stderr in turn
+//	cat        copies stdin to stdout
+//	silent     ends without exit status
+func execServer(sc *xssh.ServerConn, chans <-chan xssh.NewChannel, reqs <-chan *xssh.Request) {
+	go xssh.DiscardRequests(reqs)
+	for nc := range chans {
+		ch, creqs, _ := nc.Accept()
+		go func() {
+			for r := range creqs {
+				if r.Type != "exec" {
+					r.Reply(false, nil)
+					continue
+				}
+				var cmd struct{ Command string }
+				xssh.Unmarshal(r.Payload, &cmd)
+				r.Reply(true, nil)
+				go runCommand(ch, cmd.Command)
+			}
+		}()
+	}
+}
+
+func runCommand(ch xssh.Channel, cmd string) {
+	defer ch.Close()
+	name, arg, _ := strings.Cut(cmd, " ")
+	status := 0
+	switch name {
+	case "exit":
+		status, _ = strconv.Atoi(arg)
+	case "kill":
+		ch.SendRequest("exit-signal", false, xssh.Marshal(struct {
+			Signal  string
+			Core    bool
+			Message string
+			Lang    string
+		}{"KILL", true, "killed", "en"}))
+		return
+	case "echo":
+		ch.Write([]byte(arg))
+	case "mixed":
+		for i, w := range []io.Writer{ch, ch.Stderr(), ch} {
+			w.Write([]byte(strconv.Itoa(i)))
+			time.Sleep(50 * time.Millisecond)
+		}
+	case "cat":
+		io.Copy(ch, ch)
+	case "silent":
+		return
+	}
+	ch.SendRequest("exit-status", false, xssh.Marshal(struct{ Status uint32 }{uint32(status)}))
+}
+
+func TestSessionRun(t *testing.T) {
+	c := dialTest(t, execServer)
+	defer c.Close()
+
+	for _, cmd := range []string{"exit 0", "echo hi"} {
+		s, _ := c.NewSession()
+		if err := s.Run(cmd); err != nil {
+			t.Fatal(cmd, err)
+		}
+	}
+
+	s, _ := c.NewSession()
+	var e *ExitError
+	if err := s.Run("exit 3"); !errors.As(err, &e) || (e.Status != 3) || (len(e.Signal) > 0) {
+		t.Fatal(err)
+	}
+
+	s, _ = c.NewSession()
+	err := s.Run("kill")
+	if !errors.As(err, &e) || (e.Signal != "KILL") || !e.CoreDumped || (e.Message != "killed") || (e.Lang != "en") {
+		t.Fatal(err)
+	}
+
+	s, _ = c.NewSession()
+	if err := s.Run("silent"); (err == nil) || errors.As(err, &e) {
+		t.Fatal(err)
+	}
+
+	// a session runs one command
+	s, _ = c.NewSession()
+	if err := s.Start("exit 0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Run("exit 0"); err == nil {
+		t.Fatal("second command started")
+	}
+	if err := s.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSessionOutput(t *testing.T) {
+	c := dialTest(t, execServer)
+	defer c.Close()
+
+	s, _ := c.NewSession()
+	if out, err := s.Output("echo hello"); (string(out) != "hello") || (err != nil) {
+		t.Fatal(string(out), err)
+	}
+
+	s, _ = c.NewSession()
+	var stderr bytes.Buffer
+	s.Stderr = &stderr
+	if out, err := s.Output("mixed"); (string(out) != "02") || (stderr.String() != "1") || (err != nil) {
+		t.Fatal(string(out), stderr.String(), err)
+	}
+
+	s, _ = c.NewSession()
+	if out, err := s.CombinedOutput("mixed"); (string(out) != "012") || (err != nil) {
+		t.Fatal(string(out), err)
+	}
+
+	s, _ = c.NewSession()
+	s.Stdout = io.Discard
+	if _, err := s.Output("echo x"); err == nil {
+		t.Fatal("Output with Stdout set")
+	}
+}
+
+func TestSessionStdin(t *testing.T) {
+	c := dialTest(t, execServer)
+	defer c.Close()
+
+	s, _ := c.NewSession()
+	s.Stdin = strings.NewReader("from a reader")
+	if out, err := s.Output("cat"); (string(out) != "from a reader") || (err != nil) {
+		t.Fatal(string(out), err)
+	}
+
+	// the command sees EOF when the pipe is closed
+	s, _ = c.NewSession()
+	stdin, err := s.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := s.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start("cat"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.StdinPipe(); err == nil {
+		t.Fatal("StdinPipe after Start")
+	}
+	data := bytes.Repeat([]byte("0123456789"), 100000)
+	go func() {
+		stdin.Write(data)
+		stdin.Close()
+	}()
+	out, err := io.ReadAll(stdout)
+	if !bytes.Equal(out, data) || (err != nil) {
+		t.Fatal(len(out), err)
+	}
+	if err := s.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -5,10 +5,14 @@ import (
 	"bytes"
 	"crypto/cipher"
 	"errors"
+	"fmt"
 	"hash"
 	"io"
 )
 
+// The server hung up before the keys were exchanged.
+var errHandshakeClosed = fmt.Errorf("connection closed during handshake: %w", io.ErrUnexpectedEOF)
+
 type transport struct {
 	conn io.ReadWriteCloser
 	in *bufio.Reader
@@ -36,6 +40,9 @@ func newTransport(conn io.ReadWriteCloser) (*transport, error) {
 	var prefix bool
 	for {
 		line, prefix, err = in.ReadLine()
+		if err == io.EOF {
+			return nil, errHandshakeClosed
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -54,6 +61,16 @@ func newTransport(conn io.ReadWriteCloser) (*transport, error) {
 	return &transport{conn, in, NullCrypto{}, NullCrypto{}, NullHash{}, NullHash{}, 0, 0, make(chan []byte, 512*1024), make(chan int, 1), string(line), nil, nil, nil}, nil
 }
 
+// Take the next packet of a key exchange from the reading loop, which passes
+// an empty one when the connection ended; that one is put back for loop1.
+func (s *transport) kexPacket() ([]byte, error) {
+	if b := <-s.packets; len(b) > 0 {
+		return b, nil
+	}
+	s.packets <- nil
+	return nil, errHandshakeClosed
+}
+
 func (s *transport) readPacket() ([]byte, error) {
 	bs := s.rc.BlockSize()
 	if bs < 16 {
@@ -82,6 +99,9 @@ func (s *transport) readPacket() ([]byte, error) {
 		Log(0, "lfield %d lrest %d", lfield, lrest)
 		return nil, errors.New("too large packet")
 	}
+	if (4+lfield < len(b)) || (lpadding+1 > lfield) {
+		return nil, errors.New("malformed packet length")
+	}
 
 	packet := make([]byte, len(b)+lrest)
 	copy(packet, b)
@@ -236,7 +256,9 @@ func (s *transport) parseKexinit(b []byte) (*kexres, error) {
 	guessed = g && guessed
 
 	if !guessed && (follows > 0) {
-		<-s.packets
+		if _, err := s.kexPacket(); err != nil {
+			return nil, err
+		}
 	}
 
 	return &r, nil